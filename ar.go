@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var (
+	// malformed ar member header at %#x
+	errArHeader = "malformed ar member header at %#x"
+)
+
+const (
+	AR_MAGIC       = "!<arch>\n"
+	AR_HEADER_SIZE = 60
+)
+
+// ArMember is a member of a Unix ar(1) archive, as used by deb packages and Go
+// and C static libraries
+type ArMember struct {
+	Name   string
+	Offset int64 // offset of the member contents
+	Size   int64
+}
+
+// readArchive lists the members of the ar archive r. GNU (`//` table) and BSD
+// (`#1/len`) long names are resolved, the symbol tables are skipped.
+// reference: /src/cmd/internal/archive/archive.go
+func readArchive(r io.ReaderAt, size int64) ([]*ArMember, error) {
+	members := []*ArMember{}
+	names := ""
+
+	hdr := make([]byte, AR_HEADER_SIZE)
+	for offset := int64(len(AR_MAGIC)); offset+AR_HEADER_SIZE <= size; {
+		if _, err := r.ReadAt(hdr, offset); err != nil {
+			return nil, err
+		}
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf(errArHeader, offset)
+		}
+
+		n, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || n < 0 || offset+AR_HEADER_SIZE+n > size {
+			return nil, fmt.Errorf(errArHeader, offset)
+		}
+
+		m := &ArMember{
+			Name:   strings.TrimRight(string(hdr[0:16]), " "),
+			Offset: offset + AR_HEADER_SIZE,
+			Size:   n,
+		}
+		// members are aligned to 2 bytes
+		offset = m.Offset + n + n%2
+
+		switch {
+		case m.Name == "//":
+			b := make([]byte, m.Size)
+			if _, err := r.ReadAt(b, m.Offset); err != nil {
+				return nil, err
+			}
+			names = string(b)
+			continue
+		case m.Name == "/" || m.Name == "/SYM64/" || strings.HasPrefix(m.Name, "__.SYMDEF"):
+			continue
+		case strings.HasPrefix(m.Name, "#1/"):
+			l, err := strconv.ParseInt(m.Name[3:], 10, 64)
+			if err != nil || l > m.Size {
+				return nil, fmt.Errorf(errArHeader, m.Offset-AR_HEADER_SIZE)
+			}
+			b := make([]byte, l)
+			if _, err := r.ReadAt(b, m.Offset); err != nil {
+				return nil, err
+			}
+			m.Name = strings.TrimRight(string(b), "\x00")
+			m.Offset += l
+			m.Size -= l
+		case strings.HasPrefix(m.Name, "/") && len(m.Name) > 1:
+			i, err := strconv.Atoi(m.Name[1:])
+			if err != nil || i >= len(names) {
+				return nil, fmt.Errorf(errArHeader, m.Offset-AR_HEADER_SIZE)
+			}
+			m.Name = names[i:]
+			if end := strings.Index(m.Name, "/\n"); end >= 0 {
+				m.Name = m.Name[:end]
+			}
+		default:
+			// GNU terminates short names with a slash
+			m.Name = strings.TrimSuffix(m.Name, "/")
+		}
+
+		members = append(members, m)
+	}
+
+	return members, nil
+}
+
+// ArArchive is a static library, such as a Go c-archive whose go.o member is
+// the relocatable object holding the Go code and data
+type ArArchive struct {
+	r       io.ReaderAt
+	members []*ArMember
+}
+
+// openAr opens an ar archive, deb packages are recognized by their leading
+// debian-binary member
+func openAr(f *os.File) (Container, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := readArchive(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	if len(members) > 0 && members[0].Name == "debian-binary" {
+		return newDebPackage(f, members)
+	}
+	return &ArArchive{f, members}, nil
+}
+
+func (a *ArArchive) FormatName() string { return "ar archive" }
+
+func (a *ArArchive) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	for _, m := range a.members {
+		if err := fn(m.Name, m.Size, io.NewSectionReader(a.r, m.Offset, m.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
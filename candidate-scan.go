@@ -3,28 +3,64 @@ package main
 import (
 	"fmt"
 	"io"
+	"log/slog"
 )
 
-func findCandidates(sd *SectionData) []*FSCandidate {
-	var scan func(sd *SectionData) []*FSCandidate
-	var t string
+// ScanOptions controls candidate discovery
+type ScanOptions struct {
+	ChunkSize uint64
 
-	if sd.FileSize >= uint64(flagChunkSize) {
-		t = "chunked"
-		scan = findCandidatesChunked
-	} else {
-		t = "un-chunked"
-		scan = findCandidatesUnChunked
+	// Limits applied to every entry of a candidate
+	MaxNameLength uint64
+	MaxFileSize   int64
+	// Candidates scoring below MinConfidence (0-1) are discarded
+	MinConfidence float64
+	// Keep candidates with damaged entries instead of discarding them
+	Salvage bool
+
+	// Reports the number of bytes scanned, may be nil
+	Progress *Progress
+}
+
+// Returns the default scan options
+func DefaultScanOptions() *ScanOptions {
+	return &ScanOptions{
+		ChunkSize:     DEFAULT_CHUNK_SIZE,
+		MaxNameLength: DEFAULT_MAX_NAME_LENGTH,
+		MaxFileSize:   MAX_FILE_SIZE,
 	}
+}
+
+func findCandidates(sd *SectionData, opt *ScanOptions) []*FSCandidate {
+	candidates := []*FSCandidate{}
+
+	// tables are searched within the parts of a view, but their pointers are
+	// checked against the whole view
+	for _, part := range sd.Parts() {
+		var scan func(sd, part *SectionData, offset uint64, opt *ScanOptions) []*FSCandidate
+		var t string
+
+		if part.Bytes != nil {
+			t = "mapped"
+			scan = findCandidatesMapped
+		} else if part.FileSize >= opt.ChunkSize {
+			t = "chunked"
+			scan = findCandidatesChunked
+		} else {
+			t = "un-chunked"
+			scan = findCandidatesUnChunked
+		}
+
+		slog.Debug("Using "+t+" scan", "section", part.Name)
 
-	if flagVerbose {
-		fmt.Printf("[~] Using %s scan\n", t)
+		offset := TL_SectionOffset(sd, part.VirtualAddr+part.BaseAddr)
+		candidates = append(candidates, scan(sd, part, offset, opt)...)
 	}
 
-	return scan(sd)
+	return candidates
 }
 
-func candidateScan(sd *SectionData, buffer []byte, chunkOffset uint64, v bool) []*FSCandidate {
+func candidateScan(sd *SectionData, buffer []byte, chunkOffset uint64, opt *ScanOptions) []*FSCandidate {
 	// reference: /src/cmd/compile/internal/staticdata/embed.go#L141-L143
 	patternLength := sd.Ptrsz * 3
 	buflen := len(buffer)
@@ -33,6 +69,10 @@ func candidateScan(sd *SectionData, buffer []byte, chunkOffset uint64, v bool) [
 
 	// should be safe to increment by pointer size due to section alignment right?
 	for i := 0; i < buflen-patternLength; i += sd.Ptrsz {
+		if i > 0 && uint64(i)%PROGRESS_STEP == 0 {
+			opt.Progress.Add(PROGRESS_STEP)
+		}
+
 		addr := sd.ReadptrFrom(buffer[i : i+sd.Ptrsz])
 		s1 := sd.ReadptrFrom(buffer[i+sd.Ptrsz : i+sd.Ptrsz*2])
 		s2 := sd.ReadptrFrom(buffer[i+sd.Ptrsz*2 : i+sd.Ptrsz*3])
@@ -52,55 +92,69 @@ func candidateScan(sd *SectionData, buffer []byte, chunkOffset uint64, v bool) [
 
 		c := &FSCandidate{Addr: addr, EntryCount: s1, RelAddr: TL_SectionOffset(sd, addr), sd: sd}
 		// ensure all entries within the candidate are valid (helps eliminate false positives)
-		if !isValidCandidate(sd, c) {
+		if opt.Salvage {
+			if !salvageCandidate(sd, c, opt) {
+				continue
+			}
+		} else if !isValidCandidate(sd, c, opt) {
 			continue
 		}
-		if flagVerbose {
-			fmt.Printf("[~] Found candidate: %#08x File: %#08x (%[2]d) VA: %#08x\n", addr, curFileOffset, TL_VirtualAddress(sd, curFileOffset))
+		c.Score = ScoreCandidate(c)
+		if c.Score.Confidence < opt.MinConfidence {
+			slog.Debug("Discarded candidate", hexAttr("addr", addr), "confidence", c.Score.String())
+			continue
 		}
+		slog.Debug("Found candidate", hexAttr("addr", addr), hexAttr("file", curFileOffset), hexAttr("va", TL_VirtualAddress(sd, curFileOffset)))
 		candidates = append(candidates, c)
+		opt.Progress.Found(1)
 	}
 	return candidates
 }
 
-func findCandidatesUnChunked(sd *SectionData) []*FSCandidate {
-	buffer := make([]byte, sd.FileSize)
-	br, err := sd.Data.Read(buffer)
+// Scan the memory-mapped contents of part in place, part is located offset
+// bytes into sd
+func findCandidatesMapped(sd, part *SectionData, offset uint64, opt *ScanOptions) []*FSCandidate {
+	return candidateScan(sd, part.Bytes, offset, opt)
+}
+
+func findCandidatesUnChunked(sd, part *SectionData, offset uint64, opt *ScanOptions) []*FSCandidate {
+	buffer := make([]byte, part.FileSize)
+	br, err := part.Data.Read(buffer)
 	if err != nil {
 		panic(err)
 	}
-	if uint64(br) != sd.FileSize {
-		panic(fmt.Errorf("size mismatch between bytes read (%d) and section size (%d)", len(buffer), sd.FileSize))
+	if uint64(br) != part.FileSize {
+		panic(fmt.Errorf("size mismatch between bytes read (%d) and section size (%d)", len(buffer), part.FileSize))
 	}
 
-	return candidateScan(sd, buffer, 0, false)
+	return candidateScan(sd, buffer, offset, opt)
 }
 
-func findCandidatesChunked(sd *SectionData) []*FSCandidate {
-	chunk_cap := flagChunkSize
+func findCandidatesChunked(sd, part *SectionData, offset uint64, opt *ScanOptions) []*FSCandidate {
+	chunk_cap := opt.ChunkSize
 	chunk_buf := make([]byte, chunk_cap)
 
 	candidates := []*FSCandidate{}
 	read_total := 0
 
-	for idx := uint64(0); idx < (sd.FileSize/chunk_cap)+1; idx++ {
-		read, err := sd.Data.Read(chunk_buf)
+	for idx := uint64(0); idx < (part.FileSize/chunk_cap)+1; idx++ {
+		read, err := part.Data.Read(chunk_buf)
 		if err != nil && err != io.EOF {
 			panic(err)
 		}
 
 		read_total += read
 
-		chunk_offset := chunk_cap * idx
-		candidates = append(candidates, candidateScan(sd, chunk_buf, chunk_offset, false)...)
+		chunk_offset := offset + chunk_cap*idx
+		candidates = append(candidates, candidateScan(sd, chunk_buf, chunk_offset, opt)...)
 
 		if read == 0 || err == io.EOF {
 			break
 		}
 	}
 
-	if uint64(read_total) != sd.FileSize {
-		panic(fmt.Errorf("size mismatch between bytes read (%d) and section size (%d)", read_total, sd.FileSize))
+	if uint64(read_total) != part.FileSize {
+		panic(fmt.Errorf("size mismatch between bytes read (%d) and section size (%d)", read_total, part.FileSize))
 	}
 
 	return candidates
@@ -108,7 +162,7 @@ func findCandidatesChunked(sd *SectionData) []*FSCandidate {
 
 // Check if a embed candidate contains valid information relative to the
 // section data. This function preserves the current cursor position
-func isValidCandidate(s *SectionData, c *FSCandidate) bool {
+func isValidCandidate(s *SectionData, c *FSCandidate, opt *ScanOptions) bool {
 	defer s.Data.Seek(s.Tell(), io.SeekStart)
 
 	// file entry { name string, data string, hash [16]byte }
@@ -130,16 +184,60 @@ func isValidCandidate(s *SectionData, c *FSCandidate) bool {
 		data_l := s.ReadptrFrom(entry[s.Ptrsz*3 : s.Ptrsz*4])
 
 		// assumes an entire candidate is invalid if one entry is invalid
-		if name_l > 255 || name_l == 0 {
+		if name_l > opt.MaxNameLength || name_l == 0 {
 			return false
 		}
 		if !s.ContainsAddr(name_p) || (!s.ContainsAddr(data_p) && data_p != 0) {
 			return false
 		}
-		if int64(data_l) > int64(MAX_FILE_SIZE) {
+		if data_l > uint64(opt.MaxFileSize) {
 			return false
 		}
 	}
 
 	return true
 }
+
+// salvageCandidate checks the entries of a candidate individually, recording
+// the damaged ones (with the reason) instead of rejecting the whole candidate.
+// A candidate is kept as long as its table fits within the section and at least
+// one entry is intact.
+func salvageCandidate(s *SectionData, c *FSCandidate, opt *ScanOptions) bool {
+	if c.EntryCount > s.FileSize/c.EntrySize() || !s.ContainsRange(c.RelAddr, c.EntryCount*c.EntrySize()) {
+		return false
+	}
+
+	damaged := map[uint64]string{}
+	for i := uint64(0); i < c.EntryCount; i++ {
+		e := c.Entry(i)
+
+		reason := e.Damage
+		switch {
+		case reason != "":
+		case uint64(len(e.Name)) > opt.MaxNameLength:
+			reason = DAMAGE_NAME_POINTER
+		case !validEntryName(e.Name):
+			reason = DAMAGE_NAME_INVALID
+		case e.Data.Size > uint64(opt.MaxFileSize):
+			reason = DAMAGE_DATA_RANGE
+		case !e.IsDir:
+			data, err := e.Read()
+			if err != nil {
+				reason = DAMAGE_DATA_RANGE
+			} else if !e.VerifyHash(data) {
+				reason = DAMAGE_HASH
+			}
+		}
+
+		if reason != "" {
+			damaged[i] = reason
+		}
+	}
+
+	if uint64(len(damaged)) == c.EntryCount {
+		return false
+	}
+
+	c.Damaged = damaged
+	return true
+}
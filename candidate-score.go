@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"unicode/utf8"
+)
+
+// Invariant is a rule obeyed by every embed table the compiler emits, along
+// with how well a candidate follows it
+type Invariant struct {
+	Name    string
+	Passed  int
+	Checked int
+}
+
+// Returns the fraction of checks which passed, invariants with nothing to check
+// are considered satisfied
+func (i *Invariant) Ratio() float64 {
+	if i.Checked == 0 {
+		return 1
+	}
+	return float64(i.Passed) / float64(i.Checked)
+}
+
+func (i *Invariant) check(ok bool) {
+	i.Checked++
+	if ok {
+		i.Passed++
+	}
+}
+
+// CandidateScore measures how closely a candidate resembles a real embed table
+type CandidateScore struct {
+	Confidence float64 // average of the invariant ratios, 0-1
+	Invariants []*Invariant
+}
+
+// embedFileLess reports whether x sorts before y in the order used by the
+// compiler when writing embed tables
+// reference: /src/cmd/compile/internal/staticdata/embed.go (embedFileLess)
+func embedFileLess(x, y string) bool {
+	xdir, xelem, _ := split(x)
+	ydir, yelem, _ := split(y)
+	return xdir < ydir || xdir == ydir && xelem < yelem
+}
+
+// ScoreCandidate checks the entries of a candidate against the invariants of
+// embed tables:
+//   - names are sorted in embed's dir-then-element order
+//   - directories end in `/` and have no data and a zero hash
+//   - files have a non-zero hash
+//   - the parent directory of every entry is present
+//   - names are unique, valid UTF-8 and do not contain `.` or `..` elements
+func ScoreCandidate(c *FSCandidate) *CandidateScore {
+	sorted := &Invariant{Name: "sorted"}
+	dirs := &Invariant{Name: "directories"}
+	files := &Invariant{Name: "files"}
+	parents := &Invariant{Name: "parents"}
+	names := &Invariant{Name: "names"}
+
+	entries := c.Entries()
+
+	present := map[string]bool{}
+	for _, e := range entries {
+		present[e.Name] = true
+	}
+
+	seen := map[string]bool{}
+	for i, e := range entries {
+		if i > 0 {
+			sorted.check(embedFileLess(entries[i-1].Name, e.Name))
+		}
+
+		if e.IsDir {
+			dirs.check(e.Data.Size == 0 && e.VirtualAddr() == 0 && e.Hash == [16]byte{})
+		} else {
+			files.check(e.Hash != [16]byte{})
+		}
+
+		dir, _, _ := split(e.Name)
+		parents.check(dir == "." || present[dir+"/"])
+
+		name := trimSlash(e.Name)
+		names.check(!seen[name] && utf8.ValidString(e.Name) && fs.ValidPath(name))
+		seen[name] = true
+	}
+
+	score := &CandidateScore{Invariants: []*Invariant{sorted, dirs, files, parents, names}}
+	for _, inv := range score.Invariants {
+		score.Confidence += inv.Ratio()
+	}
+	score.Confidence /= float64(len(score.Invariants))
+
+	return score
+}
+
+// Returns a short description of the confidence, e.g. "100%"
+func (s *CandidateScore) String() string {
+	return fmt.Sprintf("%.0f%%", s.Confidence*100)
+}
+
+// Output the invariants which were not satisfied by every entry
+func writeScoreDetails(writer io.Writer, s *CandidateScore) {
+	for _, inv := range s.Invariants {
+		if inv.Passed != inv.Checked {
+			fmt.Fprintf(writer, "    [!] %s: %d/%d checks passed\n", inv.Name, inv.Passed, inv.Checked)
+		}
+	}
+}
@@ -1,14 +1,33 @@
 package main
 
 import (
+	"fmt"
 	"io"
+	"io/fs"
 	"strings"
 )
 
+// Reasons an entry of a salvaged candidate is marked as damaged
+const (
+	DAMAGE_NAME_POINTER string = "bad name pointer"
+	DAMAGE_NAME_INVALID string = "invalid name"
+	DAMAGE_DATA_RANGE   string = "data out of range"
+	DAMAGE_HASH         string = "hash mismatch"
+)
+
+// Salvaged entries whose names could not be read, or would resolve outside of
+// the output directory, are named after their index inside this directory
+const SALVAGE_DIR string = "_salvaged"
+
 type FSCandidate struct {
 	Addr       uint64 // Virtual address
 	RelAddr    uint64 // Relative section address
 	EntryCount uint64
+	Score      *CandidateScore
+
+	// Damaged maps the index of every damaged entry to the reason, only set for
+	// candidates recovered in salvage mode
+	Damaged map[uint64]string
 
 	sd *SectionData
 }
@@ -47,9 +66,23 @@ func (c *FSCandidate) Entry(i uint64) *FSCEntry {
 	}
 	entry := NewFSCEFromBuffer(buf, c.sd)
 	// fmt.Printf("entry:%d (%s) VA: %#x RSA: %#x\n", i, entry.Name, rva, rsa)
+
+	if reason, damaged := c.Damaged[i]; damaged {
+		entry.Damage = reason
+		// salvaged entries are extracted under a name of their own
+		if !validEntryName(entry.Name) {
+			entry.Name = fmt.Sprintf("%s/entry-%d", SALVAGE_DIR, i)
+		}
+	}
 	return entry
 }
 
+// validEntryName reports whether name is a relative path that stays inside the
+// directory it is extracted to
+func validEntryName(name string) bool {
+	return name != "" && fs.ValidPath(trimSlash(name))
+}
+
 type blob struct {
 	Addr uint64
 	Size uint64
@@ -62,11 +95,22 @@ type FSCEntry struct {
 	Hash [16]byte
 
 	IsDir bool
+	// Damage holds the reason the entry is unusable or untrustworthy, empty for
+	// intact entries
+	Damage string
 
 	sd *SectionData
 }
 
+// Returns true if the contents of the entry can not be read
+func (f *FSCEntry) Unreadable() bool {
+	return f.Damage == DAMAGE_DATA_RANGE
+}
+
 func (f *FSCEntry) Read() ([]byte, error) {
+	if f.Data.Size == 0 {
+		return []byte{}, nil
+	}
 	return f.sd.ReadAt(int64(f.Data.Addr), f.Data.Size, io.SeekStart)
 }
 
@@ -77,14 +121,26 @@ func NewFSCEFromBuffer(b []byte, s *SectionData) *FSCEntry {
 	data_p := TL_SectionOffset(s, s.ReadptrFrom(b[s.Ptrsz*2:s.Ptrsz*3]))
 	data_l := s.ReadptrFrom(b[s.Ptrsz*3 : s.Ptrsz*4])
 
+	f := FSCEntry{Data: blob{data_p, data_l}, sd: s}
+	copy(f.Hash[:], b[s.Ptrsz*4:])
+
+	if data_l > 0 && !s.ContainsRange(data_p, data_l) {
+		f.Damage = DAMAGE_DATA_RANGE
+	}
+	if name_l == 0 || !s.ContainsRange(name_p, name_l) {
+		if f.Damage == "" {
+			f.Damage = DAMAGE_NAME_POINTER
+		}
+		return &f
+	}
+
 	name_b, err := s.ReadAt(int64(name_p), name_l, io.SeekStart)
 	if err != nil {
 		panic(err)
 	}
 
-	f := FSCEntry{Name: string(name_b), Data: blob{data_p, data_l}, sd: s}
+	f.Name = string(name_b)
 	f.IsDir = strings.HasSuffix(f.Name, "/")
-	copy(f.Hash[:], b[s.Ptrsz*4:])
 
 	return &f
 }
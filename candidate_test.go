@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func TestInvalidEntryNames(t *testing.T) {
+	files := []embedFile{{"a.txt", "hello"}, {"../b.txt", "world"}}
+	img := make([]byte, 0x200)
+	putEmbedFS(img, binary.LittleEndian, 0x100, 0x1100, 0, 0x1000, files)
+
+	for _, salvage := range []bool{false, true} {
+		sd := &SectionData{Name: "test", VirtualAddr: 0x1000, VirtualSize: 0x200, FileSize: 0x200, Order: binary.LittleEndian, Ptrsz: 8}
+		sd.Load(img)
+		opt := DefaultScanOptions()
+		opt.Salvage = salvage
+
+		candidates := findCandidates(sd, opt)
+		if len(candidates) != 1 {
+			t.Fatalf("salvage %v: got %d candidates, want 1", salvage, len(candidates))
+		}
+		c := candidates[0]
+
+		// outside of salvage mode the name is kept, and the entry skipped when
+		// extracted
+		name, damage := "../b.txt", ""
+		if salvage {
+			name, damage = fmt.Sprintf("%s/entry-1", SALVAGE_DIR), DAMAGE_NAME_INVALID
+		}
+		if e := c.Entry(1); e.Name != name || e.Damage != damage {
+			t.Errorf("salvage %v: entry %q damage %q, want %q damage %q", salvage, e.Name, e.Damage, name, damage)
+		}
+		if e := c.Entry(0); e.Name != "a.txt" || e.Damage != "" {
+			t.Errorf("salvage %v: entry %q damage %q, want a.txt intact", salvage, e.Name, e.Damage)
+		}
+	}
+}
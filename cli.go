@@ -0,0 +1,565 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	// unknown command \"%s\"
+	errUnknownCommand = "unknown command \"%s\""
+	// no command specified
+	errNoCommand = "no command specified"
+)
+
+// Options holds the parsed command line. Global options apply to every command,
+// the remaining fields are only registered by the commands that use them.
+type Options struct {
+	// Global options
+	ChunkSize     uint64
+	Verbose       bool
+	MinConfidence float64 // percentage
+	MaxNameLength uint64
+	MaxFileSize   int64
+	Salvage       bool
+	NoMmap        bool
+	Force         bool
+	Quiet         bool
+	LogFormat     string
+	Member        string
+	Raw           bool
+	RawOptions    RawOptions
+	Pid           int
+
+	// Positional arguments following the command options
+	Target string
+	Path   string
+
+	Candidate int
+	Output    string
+	Filter    EntryFilter
+
+	// ls
+	Long bool
+
+	// tree
+	Tree TreeOptions
+
+	// extract
+	Dedup    bool
+	StoreDir string
+
+	// overlay
+	Extract bool
+
+	// grep
+	Pattern string
+	Context int
+	Count   bool
+	Text    bool
+}
+
+// NewOptions returns the default options
+func NewOptions() *Options {
+	return &Options{
+		ChunkSize:     DEFAULT_CHUNK_SIZE,
+		MaxNameLength: DEFAULT_MAX_NAME_LENGTH,
+		MaxFileSize:   MAX_FILE_SIZE,
+		Candidate:     -1,
+		StoreDir:      DEFAULT_STORE_DIR,
+		LogFormat:     "text",
+		RawOptions:    RawOptions{Ptrsz: 8, Order: "little"},
+		Tree:          TreeOptions{Charset: "unicode"},
+	}
+}
+
+// Command describes a gorip subcommand
+type Command struct {
+	Name    string
+	Summary string
+	Usage   string
+
+	// Flags registers the command specific options
+	Flags func(fs *flag.FlagSet, o *Options)
+	// Args validates and stores the positional arguments
+	Args func(o *Options, args []string) error
+	// Run executes the command
+	Run func(o *Options) error
+}
+
+const (
+	globalUsage string = `Global options:
+  -c, --chunk-size <size>
+      Set chunk size in bytes (default: 16777216 (16 MB))
+
+  -v, --verbose
+      Increase verbosity
+
+  -q, --quiet
+      Suppress progress reporting, summaries and informational messages
+
+  --log-format <format>
+      Format of the diagnostics written to stderr, "text" or "json" (default: text)
+
+  --min-confidence <percent>
+      Discard candidates scoring below the given confidence (default: 0)
+
+  --max-name-length <length>
+      Maximum length of an entry name in bytes (default: 255)
+
+  --max-file-size <size>
+      Maximum size of an embedded file in bytes (default: 2000000000 (~2 GB))
+
+  --salvage
+      Keep candidates containing damaged entries, marking each damaged entry with
+      the reason and recovering the intact ones
+
+  --no-mmap
+      Read sections through the file instead of memory-mapping it (Linux only)
+
+  --force
+      Scan executables that are not identified as Go programs, which are
+      skipped otherwise
+
+  --member <path>
+      Select the executable at path inside a container image tarball or an
+      APK, JAR, deb or rpm package. Without it, scan reports every Go
+      executable of the container
+
+  --pid <pid>
+      Scan the live memory of a running process instead of a file (Linux only).
+      The readable mappings of the main executable are read from /proc, the
+      <binary> argument is omitted
+
+  --raw
+      Treat the target as a raw memory image instead of an executable, the whole
+      file is scanned as a single section
+
+  --raw-base <address>
+      Virtual address of the first byte of the raw image (default: 0)
+
+  --raw-ptr-size <size>
+      Pointer size of the raw image in bytes, 4 or 8 (default: 8)
+
+  --raw-byte-order <order>
+      Byte order of the raw image, "little" or "big" (default: little)`
+
+	filterUsage string = `  --include <pattern>
+      Only select entries matching the pattern, can be repeated
+
+  --exclude <pattern>
+      Skip entries matching the pattern, can be repeated`
+
+	candidateUsage string = `  -i, --candidate <index>
+      Restrict the command to a single candidate (default: first match)`
+)
+
+var commands = []*Command{
+	{
+		Name:    "scan",
+		Summary: "Locate embed.FS candidates and summarize them",
+		Usage:   `Usage: ./gorip scan [options] <binary>`,
+		Args:    targetArgs,
+		Run:     runScan,
+	},
+	{
+		Name:    "info",
+		Summary: "Show the executable format, Go version and scanned section",
+		Usage:   `Usage: ./gorip info [options] <binary>`,
+		Args:    targetArgs,
+		Run:     runInfo,
+	},
+	{
+		Name:    "extract",
+		Summary: "Extract candidates to the invocation directory",
+		Usage: `Usage: ./gorip extract [options] <binary>
+
+Options:
+  -d, --dedup
+      Write each unique file once into a content-addressed store, linking the
+      named paths to it. Files whose contents do not match their hash are
+      written in place and reported (default: false)
+
+  -s, --store <dir>
+      Directory of the content-addressed store used by --dedup (default: .gorip-objects)
+
+` + candidateUsage + `
+
+` + filterUsage,
+		Flags: func(fs *flag.FlagSet, o *Options) {
+			fs.BoolVar(&o.Dedup, "dedup", o.Dedup, "")
+			fs.BoolVar(&o.Dedup, "d", o.Dedup, "")
+			fs.StringVar(&o.StoreDir, "store", o.StoreDir, "")
+			fs.StringVar(&o.StoreDir, "s", o.StoreDir, "")
+			candidateFlag(fs, o)
+			filterFlags(fs, o)
+		},
+		Args: targetArgs,
+		Run:  runExtract,
+	},
+	{
+		Name:    "manifest",
+		Summary: "Generate a candidate manifest",
+		Usage: `Usage: ./gorip manifest [options] <binary>
+
+Options:
+  -o, --output <file>
+      Write the manifest to file, "-" for stdout (default: <binary>.manifest)`,
+		Flags: outputFlag,
+		Args:  targetArgs,
+		Run:   runManifest,
+	},
+	{
+		Name:    "tree",
+		Summary: "Generate a file tree",
+		Usage: `Usage: ./gorip tree [options] <binary>
+
+Options:
+  -o, --output <file>
+      Write the tree to file, "-" for stdout (default: <binary>.tree)
+
+  --charset <unicode|ascii>
+      Characters used to draw the tree (default: unicode)
+
+  -s, --size
+      Print the size of each file in bytes
+
+  -H, --human
+      Print sizes in a human readable format (e.g. 1.2K, 34M)
+
+  --du
+      Print the aggregate size and file count of each directory (implies --size)
+
+  --hash
+      Print the notsha256 hash of each file
+
+  -L, --depth <level>
+      Descend at most level directories deep (default: 0, no limit)
+
+  -J, --json
+      Output a nested JSON tree
+
+  --merge
+      Merge every candidate into a single root instead of one root per candidate`,
+		Flags: func(fs *flag.FlagSet, o *Options) {
+			outputFlag(fs, o)
+			fs.StringVar(&o.Tree.Charset, "charset", o.Tree.Charset, "")
+			fs.BoolVar(&o.Tree.Size, "size", o.Tree.Size, "")
+			fs.BoolVar(&o.Tree.Size, "s", o.Tree.Size, "")
+			fs.BoolVar(&o.Tree.Human, "human", o.Tree.Human, "")
+			fs.BoolVar(&o.Tree.Human, "H", o.Tree.Human, "")
+			fs.BoolVar(&o.Tree.DirSize, "du", o.Tree.DirSize, "")
+			fs.BoolVar(&o.Tree.Hash, "hash", o.Tree.Hash, "")
+			fs.IntVar(&o.Tree.Depth, "depth", o.Tree.Depth, "")
+			fs.IntVar(&o.Tree.Depth, "L", o.Tree.Depth, "")
+			fs.BoolVar(&o.Tree.JSON, "json", o.Tree.JSON, "")
+			fs.BoolVar(&o.Tree.JSON, "J", o.Tree.JSON, "")
+			fs.BoolVar(&o.Tree.Merge, "merge", o.Tree.Merge, "")
+		},
+		Args: targetArgs,
+		Run:  runTree,
+	},
+	{
+		Name:    "ls",
+		Summary: "List a directory inside a candidate",
+		Usage: `Usage: ./gorip ls [options] <binary> [path]
+
+Lists the directory (default: ".") in every candidate containing it. Paths are
+resolved the same way embed.FS.Open does.
+
+Options:
+  -l, --long
+      Use the long listing format (size, hash, data offset)
+
+` + candidateUsage,
+		Flags: func(fs *flag.FlagSet, o *Options) {
+			fs.BoolVar(&o.Long, "long", o.Long, "")
+			fs.BoolVar(&o.Long, "l", o.Long, "")
+			candidateFlag(fs, o)
+		},
+		Args: pathArgs(false),
+		Run:  runLs,
+	},
+	{
+		Name:    "cat",
+		Summary: "Write a single embedded file to stdout",
+		Usage: `Usage: ./gorip cat [options] <binary> <path>
+
+Options:
+` + candidateUsage,
+		Flags: candidateFlag,
+		Args:  pathArgs(true),
+		Run:   runCat,
+	},
+	{
+		Name:    "stat",
+		Summary: "Show the metadata of an embedded file or directory",
+		Usage: `Usage: ./gorip stat [options] <binary> <path>
+
+Options:
+` + candidateUsage,
+		Flags: candidateFlag,
+		Args:  pathArgs(true),
+		Run:   runStat,
+	},
+	{
+		Name:    "overlay",
+		Summary: "Report and extract data appended to the executable",
+		Usage: `Usage: ./gorip overlay [options] <binary>
+
+Reports the data following the end of the executable image described by its
+headers: its offset, size, type and entropy. The entries of zip archives are
+listed, along with those of the archives they contain.
+
+Options:
+  -x, --extract
+      Write the overlay to the output file
+
+  -o, --output <file>
+      Write the overlay to file, "-" for stdout (default: <binary>.overlay)`,
+		Flags: func(fs *flag.FlagSet, o *Options) {
+			fs.BoolVar(&o.Extract, "extract", o.Extract, "")
+			fs.BoolVar(&o.Extract, "x", o.Extract, "")
+			outputFlag(fs, o)
+		},
+		Args: targetArgs,
+		Run:  runOverlay,
+	},
+	{
+		Name:    "grep",
+		Summary: "Search the contents of every embedded file",
+		Usage: `Usage: ./gorip grep [options] <regex> <binary>
+
+Matches are printed as candidate:path:line: match. Binary files (files
+containing a NUL byte) only report whether they match.
+
+Options:
+  -C, --context <lines>
+      Print lines of context around matches (default: 0)
+
+  --count
+      Only print the number of matching lines per file
+
+  -a, --text
+      Search binary files as if they were text
+
+` + filterUsage,
+		Flags: func(fs *flag.FlagSet, o *Options) {
+			fs.IntVar(&o.Context, "context", o.Context, "")
+			fs.IntVar(&o.Context, "C", o.Context, "")
+			fs.BoolVar(&o.Count, "count", o.Count, "")
+			fs.BoolVar(&o.Text, "text", o.Text, "")
+			fs.BoolVar(&o.Text, "a", o.Text, "")
+			filterFlags(fs, o)
+		},
+		Args: func(o *Options, args []string) error {
+			args = processArgs(o, args, 1)
+			if len(args) != 2 {
+				return errors.New("expected <regex> and <binary> arguments")
+			}
+			o.Pattern, o.Target = args[0], args[1]
+			return nil
+		},
+		Run: runGrep,
+	},
+}
+
+// Registers the options shared by every command. The current values are used as
+// defaults so options given before the command are preserved.
+func globalFlags(fs *flag.FlagSet, o *Options) {
+	fs.Uint64Var(&o.ChunkSize, "chunk-size", o.ChunkSize, "")
+	fs.Uint64Var(&o.ChunkSize, "c", o.ChunkSize, "")
+	fs.BoolVar(&o.Verbose, "verbose", o.Verbose, "")
+	fs.BoolVar(&o.Verbose, "v", o.Verbose, "")
+	fs.BoolVar(&o.Quiet, "quiet", o.Quiet, "")
+	fs.BoolVar(&o.Quiet, "q", o.Quiet, "")
+	fs.StringVar(&o.LogFormat, "log-format", o.LogFormat, "")
+	fs.Float64Var(&o.MinConfidence, "min-confidence", o.MinConfidence, "")
+	fs.Uint64Var(&o.MaxNameLength, "max-name-length", o.MaxNameLength, "")
+	fs.Int64Var(&o.MaxFileSize, "max-file-size", o.MaxFileSize, "")
+	fs.BoolVar(&o.Salvage, "salvage", o.Salvage, "")
+	fs.BoolVar(&o.NoMmap, "no-mmap", o.NoMmap, "")
+	fs.BoolVar(&o.Force, "force", o.Force, "")
+	fs.StringVar(&o.Member, "member", o.Member, "")
+	fs.IntVar(&o.Pid, "pid", o.Pid, "")
+	fs.BoolVar(&o.Raw, "raw", o.Raw, "")
+	fs.Uint64Var(&o.RawOptions.Base, "raw-base", o.RawOptions.Base, "")
+	fs.IntVar(&o.RawOptions.Ptrsz, "raw-ptr-size", o.RawOptions.Ptrsz, "")
+	fs.StringVar(&o.RawOptions.Order, "raw-byte-order", o.RawOptions.Order, "")
+}
+
+// Returns the options used to discover candidates
+func (o *Options) ScanOptions() *ScanOptions {
+	return &ScanOptions{
+		ChunkSize:     o.ChunkSize,
+		MaxNameLength: o.MaxNameLength,
+		MaxFileSize:   o.MaxFileSize,
+		MinConfidence: o.MinConfidence / 100,
+		Salvage:       o.Salvage,
+	}
+}
+
+func candidateFlag(fs *flag.FlagSet, o *Options) {
+	fs.IntVar(&o.Candidate, "candidate", o.Candidate, "")
+	fs.IntVar(&o.Candidate, "i", o.Candidate, "")
+}
+
+func filterFlags(fs *flag.FlagSet, o *Options) {
+	fs.Var(&o.Filter.Include, "include", "")
+	fs.Var(&o.Filter.Exclude, "exclude", "")
+}
+
+func outputFlag(fs *flag.FlagSet, o *Options) {
+	fs.StringVar(&o.Output, "output", o.Output, "")
+	fs.StringVar(&o.Output, "o", o.Output, "")
+}
+
+// processArgs inserts the name of the process selected with --pid at position
+// i of the positional arguments, so commands accept their usual arguments
+// without <binary>
+func processArgs(o *Options, args []string, i int) []string {
+	if o.Pid == 0 || i > len(args) {
+		return args
+	}
+	return append(args[:i:i], append([]string{fmt.Sprintf("pid-%d", o.Pid)}, args[i:]...)...)
+}
+
+func targetArgs(o *Options, args []string) error {
+	args = processArgs(o, args, 0)
+	if len(args) != 1 {
+		return errors.New("expected a single <binary> argument")
+	}
+	o.Target = args[0]
+	return nil
+}
+
+// pathArgs accepts `<binary> [path]`, the path defaults to "." unless required
+func pathArgs(required bool) func(o *Options, args []string) error {
+	return func(o *Options, args []string) error {
+		args = processArgs(o, args, 0)
+		switch {
+		case len(args) == 2:
+			o.Target, o.Path = args[0], args[1]
+		case len(args) == 1 && !required:
+			o.Target, o.Path = args[0], "."
+		default:
+			return errors.New("expected <binary> and <path> arguments")
+		}
+		return nil
+	}
+}
+
+// Returns the command with the given name, or nil
+func lookupCommand(name string) *Command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Write the top level usage listing every command
+func printUsage(w io.Writer) {
+	var b strings.Builder
+
+	b.WriteString("Usage: ./gorip [global options] <command> [options] <args>\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "  %-9s %s\n", c.Name, c.Summary)
+	}
+	b.WriteString("\n" + globalUsage + "\n\n")
+	b.WriteString(`Run "./gorip help <command>" for the options of a command. <binary> may be "-"
+to read from stdin, gzip, xz, zstd and bzip2 compressed binaries are accepted,
+as well as container image tarballs (docker save, OCI layout), packages,
+static libraries, relocatable objects, WebAssembly modules, ELF core dumps,
+Windows minidumps and UPX packed executables.
+
+Examples:
+  ./gorip scan ./path/to/binary
+  ./gorip --min-confidence 90 scan ./path/to/binary
+  ./gorip -c 1048576 extract ./path/to/binary
+  ./gorip extract --dedup --store ./objects ./path/to/binary
+  ./gorip manifest -o - ./path/to/binary
+  ./gorip tree -H --du -L 2 -o - ./path/to/binary
+  ./gorip ls -l ./path/to/binary assets/gfx
+  ./gorip cat -i 1 ./path/to/binary assets/gfx/statusbox.png > statusbox.png
+  ./gorip grep -C 2 --include '*.json' 'https?://' ./path/to/binary
+  ./gorip overlay -x ./path/to/binary
+  xzcat ./path/to/binary.xz | ./gorip scan -
+  ./gorip --member /app/server extract ./path/to/image.tar
+  ./gorip scan --pid 1234`)
+
+	fmt.Fprintln(w, b.String())
+}
+
+// Write the usage of a single command
+func printCommandUsage(w io.Writer, c *Command) {
+	fmt.Fprintf(w, "%s\n\n%s\n", c.Usage, globalUsage)
+}
+
+// parseArgs parses the command line arguments (excluding the program name).
+// Global options may appear before or after the command name. Usage text is
+// written to w when help is requested, or no command is given, in which case
+// flag.ErrHelp or a "no command specified" error is returned.
+func parseArgs(argv []string, w io.Writer) (*Command, *Options, error) {
+	o := NewOptions()
+
+	gfs := flag.NewFlagSet("gorip", flag.ContinueOnError)
+	gfs.SetOutput(io.Discard)
+	globalFlags(gfs, o)
+
+	if err := gfs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			printUsage(w)
+		}
+		return nil, nil, err
+	}
+
+	argv = gfs.Args()
+	if len(argv) == 0 {
+		printUsage(w)
+		return nil, nil, errors.New(errNoCommand)
+	}
+
+	name, argv := argv[0], argv[1:]
+	if name == "help" {
+		if len(argv) > 0 {
+			if c := lookupCommand(argv[0]); c != nil {
+				printCommandUsage(w, c)
+				return nil, nil, flag.ErrHelp
+			}
+		}
+		printUsage(w)
+		return nil, nil, flag.ErrHelp
+	}
+
+	cmd := lookupCommand(name)
+	if cmd == nil {
+		return nil, nil, fmt.Errorf(errUnknownCommand, name)
+	}
+
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	globalFlags(fs, o)
+	if cmd.Flags != nil {
+		cmd.Flags(fs, o)
+	}
+
+	if err := fs.Parse(argv); err != nil {
+		if err == flag.ErrHelp {
+			printCommandUsage(w, cmd)
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("%s: %w", cmd.Name, err)
+	}
+	if err := cmd.Args(o, fs.Args()); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", cmd.Name, err)
+	}
+
+	o.ChunkSize += o.ChunkSize % 2 // chunk size should be a multiple of 2
+
+	return cmd, o, nil
+}
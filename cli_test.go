@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		cmd   string
+		err   string // substring of the error, empty on success
+		check func(o *Options) bool
+	}{
+		// global options before or after the command
+		{args: "-c 1024 scan bin", cmd: "scan", check: func(o *Options) bool { return o.ChunkSize == 1024 && o.Target == "bin" }},
+		{args: "scan -v --salvage bin", cmd: "scan", check: func(o *Options) bool { return o.Verbose && o.Salvage }},
+		{args: "--min-confidence 90 info -q bin", cmd: "info", check: func(o *Options) bool { return o.MinConfidence == 90 && o.Quiet }},
+		{args: "-c 1023 scan bin", cmd: "scan", check: func(o *Options) bool { return o.ChunkSize == 1024 }},
+
+		// command options only after their command
+		{args: "extract -d -s objs -i 1 bin", cmd: "extract", check: func(o *Options) bool { return o.Dedup && o.StoreDir == "objs" && o.Candidate == 1 }},
+		{args: "extract --include *.json --include *.js bin", cmd: "extract", check: func(o *Options) bool { return len(o.Filter.Include) == 2 }},
+		{args: "tree -s -H --du -L 2 bin", cmd: "tree", check: func(o *Options) bool { return o.Tree.Size && o.Tree.Human && o.Tree.DirSize && o.Tree.Depth == 2 }},
+		{args: "-d extract bin", err: "flag provided but not defined"},
+		{args: "scan -d bin", err: "scan: flag provided but not defined"},
+		{args: "cat -l bin a", err: "cat: flag provided but not defined"},
+
+		// positional arguments
+		{args: "ls bin", cmd: "ls", check: func(o *Options) bool { return o.Target == "bin" && o.Path == "." }},
+		{args: "ls -l bin assets", cmd: "ls", check: func(o *Options) bool { return o.Long && o.Path == "assets" }},
+		{args: "cat bin a/b.txt", cmd: "cat", check: func(o *Options) bool { return o.Path == "a/b.txt" }},
+		{args: "grep -C 2 re bin", cmd: "grep", check: func(o *Options) bool { return o.Pattern == "re" && o.Target == "bin" && o.Context == 2 }},
+		{args: "scan", err: "expected a single <binary> argument"},
+		{args: "scan a b", err: "expected a single <binary> argument"},
+		{args: "cat bin", err: "expected <binary> and <path> arguments"},
+		{args: "stat bin a b", err: "expected <binary> and <path> arguments"},
+		{args: "ls bin a b", err: "expected <binary> and <path> arguments"},
+		{args: "grep re", err: "expected <regex> and <binary> arguments"},
+
+		// --pid takes the place of <binary>
+		{args: "--pid 12 scan", cmd: "scan", check: func(o *Options) bool { return o.Pid == 12 && o.Target == "pid-12" }},
+		{args: "--pid 12 ls assets", cmd: "ls", check: func(o *Options) bool { return o.Target == "pid-12" && o.Path == "assets" }},
+		{args: "--pid 12 grep re", cmd: "grep", check: func(o *Options) bool { return o.Pattern == "re" && o.Target == "pid-12" }},
+
+		{args: "", err: errNoCommand},
+		{args: "-v", err: errNoCommand},
+		{args: "unpack bin", err: `unknown command "unpack"`},
+		{args: "--bogus scan bin", err: "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		cmd, o, err := parseArgs(strings.Fields(tt.args), &bytes.Buffer{})
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%q: error %v, want %q", tt.args, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.args, err)
+			continue
+		}
+		if cmd.Name != tt.cmd {
+			t.Errorf("%q: command %s, want %s", tt.args, cmd.Name, tt.cmd)
+		}
+		if tt.check != nil && !tt.check(o) {
+			t.Errorf("%q: unexpected options %+v", tt.args, o)
+		}
+	}
+}
+
+func TestParseArgsHelp(t *testing.T) {
+	tests := []struct {
+		args  string
+		usage string // start of the usage written
+	}{
+		{"help", "Usage: ./gorip [global options]"},
+		{"-h", "Usage: ./gorip [global options]"},
+		{"help tree", "Usage: ./gorip tree [options]"},
+		{"help unpack", "Usage: ./gorip [global options]"},
+		{"tree -h", "Usage: ./gorip tree [options]"},
+		{"-v grep --help", "Usage: ./gorip grep [options]"},
+	}
+
+	for _, tt := range tests {
+		w := &bytes.Buffer{}
+		_, _, err := parseArgs(strings.Fields(tt.args), w)
+
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Errorf("%q: error %v, want flag.ErrHelp", tt.args, err)
+		}
+		if !strings.HasPrefix(w.String(), tt.usage) {
+			t.Errorf("%q: usage starts with %q, want %q", tt.args, strings.SplitN(w.String(), "\n", 2)[0], tt.usage)
+		}
+	}
+
+	// every command documents the global options
+	for _, c := range commands {
+		w := &bytes.Buffer{}
+		parseArgs([]string{"help", c.Name}, w)
+		if !strings.Contains(w.String(), "Global options:") {
+			t.Errorf("help %s: global options missing", c.Name)
+		}
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// returned by grep to exit with a non-zero status without printing an error,
+// mirroring grep(1)
+var errNoMatches = errors.New("no matches")
+
+// Target is an opened executable along with the section scanned for candidates
+type Target struct {
+	File io.ReaderAt
+	Exe  exe
+	// Sections lists the sections scanned for candidates, most executables
+	// only have their read-only data section scanned
+	Sections []*SectionData
+
+	// Timer records the duration of each step performed on the target
+	Timer PhaseTimer
+	// Go is set once the target is identified
+	Go *GoIdentity
+
+	unmap func() error
+	close func() error
+}
+
+// Open the target binary and locate the section containing embed tables
+func openTarget(o *Options) (*Target, error) {
+	start := time.Now()
+	if o.Pid != 0 {
+		return openProcess(o.Pid, start)
+	}
+
+	f, c, err := openSource(o)
+	if err != nil {
+		return nil, err
+	}
+
+	return sourceTarget(f, c, o, start)
+}
+
+// sourceTarget returns the target of an opened source. The executable selected
+// with --member is used when the source is a container.
+func sourceTarget(f *os.File, c Container, o *Options, start time.Time) (*Target, error) {
+	if c == nil {
+		return newTarget(f, o, start)
+	}
+	defer f.Close()
+
+	if o.Member == "" {
+		return nil, fmt.Errorf(errContainerMember, o.Target, c.FormatName())
+	}
+	data, err := openMember(c, o.Member)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryTarget(data, start)
+}
+
+// newTarget locates the section containing embed tables of the executable f,
+// which is closed on failure. The open phase is timed from start.
+func newTarget(f *os.File, o *Options, start time.Time) (*Target, error) {
+	x, err := detectTarget(f, o)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	ux, data, err := unpackUPX(x, f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if ux != nil {
+		f.Close()
+		return memoryTarget(ux, data, start)
+	}
+
+	sections, err := x.Sections()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	t := &Target{File: f, Exe: x, Sections: sections, close: f.Close}
+	if !o.NoMmap {
+		t.mmap(f)
+	}
+
+	t.Timer.Track("open", start, 0)
+	return t, nil
+}
+
+// newMemoryTarget locates the section containing embed tables of an executable
+// held in memory, such as a container member. The section reads directly from
+// data.
+func newMemoryTarget(data []byte, start time.Time) (*Target, error) {
+	x, err := DetectExeFormat(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	ux, unpacked, err := unpackUPX(x, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if ux != nil {
+		x, data = ux, unpacked
+	}
+	return memoryTarget(x, data, start)
+}
+
+// memoryTarget locates the section containing embed tables of the executable
+// x, whose contents are data
+func memoryTarget(x exe, data []byte, start time.Time) (*Target, error) {
+	sections, err := x.Sections()
+	if err != nil {
+		return nil, err
+	}
+	for _, sd := range sections {
+		sd.Attach(data)
+	}
+
+	t := &Target{File: bytes.NewReader(data), Exe: x, Sections: sections}
+	t.Timer.Track("open", start, 0)
+	return t, nil
+}
+
+// detectTarget detects the executable format of f, unless raw mode describes
+// the contents of f
+func detectTarget(f *os.File, o *Options) (exe, error) {
+	if !o.Raw {
+		return DetectExeFormat(f)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return NewRawExe(f, info.Size(), &o.RawOptions)
+}
+
+// mmap backs the sections with a memory mapping of the file when possible,
+// otherwise the sections keep reading through the file
+func (t *Target) mmap(f *os.File) {
+	image, unmap, err := mmapFile(f)
+	if err != nil {
+		slog.Debug("Not using mmap", "err", err)
+		return
+	}
+
+	attached := false
+	for _, sd := range t.Sections {
+		attached = sd.Attach(image) || attached
+	}
+	if !attached {
+		unmap()
+		return
+	}
+	t.unmap = unmap
+}
+
+// Returns the combined size of the scanned sections
+func (t *Target) Size() uint64 {
+	size := uint64(0)
+	for _, sd := range t.Sections {
+		size += sd.ScanSize()
+	}
+	return size
+}
+
+func (t *Target) Close() error {
+	if t.unmap != nil {
+		t.unmap()
+	}
+	if t.close != nil {
+		return t.close()
+	}
+	return nil
+}
+
+// Scan the target sections for candidates
+func (t *Target) Candidates(o *Options) []*FSCandidate {
+	candidates := []*FSCandidate{}
+	if id := t.Identify(); !id.IsGo && id.Conclusive && !o.Force {
+		slog.Warn("Not a Go executable, skipping the scan (--force scans it anyway)")
+		t.Timer.Track("scan", time.Now(), 0)
+		return candidates
+	}
+
+	opt := o.ScanOptions()
+	opt.Progress = NewProgress("Scanning", t.Size(), !o.Quiet)
+
+	start := time.Now()
+	scanned := uint64(0)
+
+	for _, sd := range t.Sections {
+		opt.Progress.Start(scanned)
+		candidates = append(candidates, findCandidates(sd, opt)...)
+		scanned += sd.ScanSize()
+	}
+
+	opt.Progress.Done()
+	t.Timer.Track("scan", start, scanned)
+
+	return candidates
+}
+
+// selectCandidates narrows the candidates down to the one chosen with
+// --candidate, if any
+func selectCandidates(candidates []*FSCandidate, index int) ([]*FSCandidate, error) {
+	if index < 0 {
+		return candidates, nil
+	}
+	if index >= len(candidates) {
+		return nil, fmt.Errorf("candidate %d does not exist (%d found)", index, len(candidates))
+	}
+	return candidates[index : index+1], nil
+}
+
+// nopWriteCloser prevents stdout from being closed along with a report
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// createOutput opens the destination of a generated report. An empty name uses
+// the default `<binary><ext>` in the invocation directory, "-" uses stdout.
+func createOutput(o *Options, ext string) (io.WriteCloser, error) {
+	name := o.Output
+	switch name {
+	case "-":
+		return nopWriteCloser{os.Stdout}, nil
+	case "":
+		name = filepath.Base(targetName(o.Target) + ext)
+	}
+
+	return os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+}
+
+// Scan the target and log the time taken, shared by the commands which report
+// progress information
+func scanTarget(t *Target, o *Options) []*FSCandidate {
+	slog.Info("Detected executable", "format", t.Exe.FormatName())
+	for _, sd := range t.Sections {
+		logSectionInfo(sd)
+	}
+	if id := t.Identify(); id.IsGo {
+		slog.Info("Identified Go executable", "version", id.Version, "method", id.Method)
+	}
+
+	candidates := t.Candidates(o)
+
+	scan := t.Timer.Phases[len(t.Timer.Phases)-1]
+	slog.Info("Candidate(s) found", "count", len(candidates), "took", scan.Elapsed, "throughput", scan.Throughput())
+
+	return candidates
+}
+
+// Output a line per candidate with its location, contents and confidence
+func writeCandidateSummary(writer io.Writer, candidates []*FSCandidate, verbose bool) {
+	for i, candidate := range candidates {
+		size, d := uint64(0), 0
+		for _, e := range candidate.Entries() {
+			size += e.Data.Size
+			if e.IsDir {
+				d += 1
+			}
+		}
+
+		fmt.Fprintf(writer, "  %d: VA: %#x FO: %#x %d files %d folders %d (bytes) confidence: %s\n",
+			i, candidate.Addr, TL_FileOffset(candidate.sd, candidate.Addr), int(candidate.EntryCount)-d, d, size, candidate.Score)
+		if len(candidate.Damaged) > 0 {
+			fmt.Fprintf(writer, "    [!] %d damaged entries\n", len(candidate.Damaged))
+		}
+		if verbose {
+			writeScoreDetails(writer, candidate.Score)
+		}
+	}
+}
+
+func runScan(o *Options) error {
+	start := time.Now()
+
+	var t *Target
+	if o.Pid != 0 {
+		p, err := openProcess(o.Pid, start)
+		if err != nil {
+			return err
+		}
+		t = p
+	} else {
+		f, c, err := openSource(o)
+		if err != nil {
+			return err
+		}
+		if c != nil && o.Member == "" {
+			defer f.Close()
+			return scanContainer(os.Stdout, o, c)
+		}
+
+		if t, err = sourceTarget(f, c, o, start); err != nil {
+			return err
+		}
+	}
+	defer t.Close()
+
+	writeCandidateSummary(os.Stdout, scanTarget(t, o), o.Verbose)
+
+	logSummary(&t.Timer)
+	return nil
+}
+
+func runInfo(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	ident := make([]byte, 16)
+	if _, err := t.File.ReadAt(ident, 0); err != nil {
+		return err
+	}
+	fmt.Printf("Ident: %x\n", ident)
+
+	fmt.Println("Format:", t.Exe.FormatName())
+	fmt.Println("Go:", t.Identify())
+	for _, sd := range t.Sections {
+		PrintSectionInfo(os.Stdout, sd)
+	}
+
+	return nil
+}
+
+func runExtract(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	candidates, err := selectCandidates(scanTarget(t, o), o.Candidate)
+	if err != nil {
+		return err
+	}
+
+	logPathConflicts(FindPathConflicts(candidates))
+
+	start := time.Now()
+	progress := NewProgress("Extracting", extractSize(candidates, &o.Filter), !o.Quiet)
+
+	var written uint64
+	if o.Dedup {
+		written, err = extractCandidatesDedup(candidates, o.StoreDir, &o.Filter, progress)
+	} else {
+		written = extractCandidates(candidates, &o.Filter, progress)
+	}
+
+	progress.Done()
+	if err != nil {
+		return err
+	}
+	t.Timer.Track("extract", start, written)
+
+	logSummary(&t.Timer)
+	return nil
+}
+
+func runManifest(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	w, err := createOutput(o, ".manifest")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	generateManifest(w, t.Candidates(o))
+	return nil
+}
+
+func runTree(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	w, err := createOutput(o, ".tree")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	return generateFileTree(w, t.Candidates(o), &o.Tree)
+}
+
+func runLs(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	candidates := t.Candidates(o)
+
+	if o.Candidate >= 0 {
+		_, c, err := resolveCandidate(candidates, o.Candidate, o.Path)
+		if err != nil {
+			return err
+		}
+		return listEntries(os.Stdout, c, o.Path, o.Long)
+	}
+
+	// list the directory in every candidate that contains it
+	found := 0
+	lookupErr := fmt.Errorf(errPathNonexistent, o.Path)
+
+	for i, c := range candidates {
+		if _, _, err := c.Lookup(o.Path); err != nil {
+			lookupErr = err
+			continue
+		}
+		if found > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Candidate %d:\n", i)
+		if err := listEntries(os.Stdout, c, o.Path, o.Long); err != nil {
+			return err
+		}
+		found++
+	}
+	if found == 0 {
+		return lookupErr
+	}
+
+	return nil
+}
+
+func runCat(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	_, c, err := resolveCandidate(t.Candidates(o), o.Candidate, o.Path)
+	if err != nil {
+		return err
+	}
+	return catEntry(os.Stdout, c, o.Path)
+}
+
+func runStat(o *Options) error {
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	ci, c, err := resolveCandidate(t.Candidates(o), o.Candidate, o.Path)
+	if err != nil {
+		return err
+	}
+	return statEntry(os.Stdout, c, ci, o.Path)
+}
+
+func runGrep(o *Options) error {
+	re, err := regexp.Compile(o.Pattern)
+	if err != nil {
+		return err
+	}
+
+	t, err := openTarget(o)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	opt := &GrepOptions{
+		Pattern: re,
+		Filter:  &o.Filter,
+		Context: o.Context,
+		Count:   o.Count,
+		Text:    o.Text,
+	}
+
+	n, err := grepCandidates(os.Stdout, t.Candidates(o), opt)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errNoMatches
+	}
+
+	return nil
+}
+
+func runOverlay(o *Options) error {
+	if o.Pid != 0 {
+		return errors.New(errOverlayProcess)
+	}
+
+	f, c, err := openSource(o)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var r io.ReaderAt = f
+	size := info.Size()
+
+	if c != nil {
+		if o.Member == "" {
+			return fmt.Errorf(errContainerMember, o.Target, c.FormatName())
+		}
+		data, err := openMember(c, o.Member)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	x, err := DetectExeFormat(r)
+	if err != nil {
+		return err
+	}
+	ov, err := findOverlay(x, r, size)
+	if err != nil {
+		return err
+	}
+	if ov == nil {
+		slog.Info("No overlay, the file ends with the image", "size", size)
+		return nil
+	}
+
+	// the report is left out when the overlay itself is written to stdout
+	if !o.Extract || o.Output != "-" {
+		if err := writeOverlayInfo(os.Stdout, ov); err != nil {
+			return err
+		}
+	}
+	if !o.Extract {
+		return nil
+	}
+
+	w, err := createOutput(o, ".overlay")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	if _, err := io.Copy(w, ov.Open()); err != nil {
+		return err
+	}
+	slog.Info("Extracted overlay", "size", ov.Size)
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	// %s is a %s, select an executable with --member
+	errContainerMember = "%s is a %s, select an executable with --member"
+	// --member requires a container target
+	errNotContainer = "--member requires a container target"
+	// member %s does not exist
+	errMemberNonexistent = "member %s does not exist"
+	// member %s: size %d exceeds the maximum file size
+	errMemberSize = "member %s: size %d exceeds the maximum file size"
+
+	// returned by a walk function to stop walking the container
+	errStopWalk = errors.New("stop walk")
+)
+
+// Container is an archive bundling executables, such as a container image or a
+// package. Members are identified by their path inside the container.
+type Container interface {
+	FormatName() string
+	// Walk calls fn with the contents of every regular file of the container.
+	// The reader is only valid until fn returns.
+	Walk(fn func(name string, size int64, r io.Reader) error) error
+}
+
+// DetectContainer returns the container stored in f, or nil if f is not a
+// recognized container
+func DetectContainer(f *os.File) (Container, error) {
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	head = head[:n]
+
+	switch {
+	case isTar(head):
+		return detectImage(f)
+	case bytes.HasPrefix(head, []byte(ZIP_MAGIC)):
+		return openZip(f)
+	case bytes.HasPrefix(head, []byte(AR_MAGIC)):
+		return openAr(f)
+	case bytes.HasPrefix(head, []byte(RPM_MAGIC)):
+		return openRPM(f)
+	}
+
+	return nil, nil
+}
+
+// isExecutable reports whether the leading bytes of a file match one of the
+// executable formats supported by DetectExeFormat
+func isExecutable(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("MZ")) ||
+		bytes.HasPrefix(head, []byte("\x7fELF")) ||
+		bytes.HasPrefix(head, []byte("\xfe\xed\xfa")) ||
+		len(head) > 1 && bytes.HasPrefix(head[1:], []byte("\xfa\xed\xfe"))
+}
+
+// readMember reads the contents of a container member into memory, so members
+// are scanned without being unpacked to disk
+func readMember(name string, size int64, r io.Reader) ([]byte, error) {
+	if size < 0 || size > MAX_FILE_SIZE {
+		return nil, fmt.Errorf(errMemberSize, name, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("member %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// walkExecutables calls fn with the contents of every member of the container
+// starting with the magic of a supported executable format
+func walkExecutables(c Container, fn func(name string, data []byte) error) error {
+	return c.Walk(func(name string, size int64, r io.Reader) error {
+		br := bufio.NewReader(r)
+		if head, _ := br.Peek(16); !isExecutable(head) {
+			return nil
+		}
+
+		data, err := readMember(name, size, br)
+		if err != nil {
+			return err
+		}
+		return fn(name, data)
+	})
+}
+
+// openSource opens the target and detects whether it is a container. When the
+// target is not a container the returned container is nil.
+func openSource(o *Options) (*os.File, Container, error) {
+	f, err := openInput(o.Target, o.Raw)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if o.Raw {
+		// a memory image may start with any bytes
+		return f, nil, nil
+	}
+
+	c, err := DetectContainer(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if c == nil && o.Member != "" {
+		f.Close()
+		return nil, nil, errors.New(errNotContainer)
+	}
+
+	return f, c, nil
+}
+
+// openMember reads the member `name` of a container
+func openMember(c Container, name string) ([]byte, error) {
+	var member []byte
+
+	err := c.Walk(func(n string, size int64, r io.Reader) error {
+		if strings.Trim(n, "/") != strings.Trim(name, "/") {
+			return nil
+		}
+
+		data, err := readMember(n, size, r)
+		if err != nil {
+			return err
+		}
+		member = data
+		return errStopWalk
+	})
+	if err != nil && err != errStopWalk {
+		return nil, err
+	}
+	if member == nil {
+		return nil, fmt.Errorf(errMemberNonexistent, name)
+	}
+
+	return member, nil
+}
+
+// Scan every Go executable of a container and summarize the candidates of
+// each, keyed by the path of the executable inside the container. Members are
+// unpacked and identified the same way as targets, members not identified as
+// Go are skipped unless forced.
+func scanContainer(w io.Writer, o *Options, c Container) error {
+	slog.Info("Detected container", "format", c.FormatName())
+
+	found := 0
+	err := walkExecutables(c, func(name string, data []byte) error {
+		t, err := newMemoryTarget(data, time.Now())
+		if err != nil {
+			slog.Warn("Skipping member", "member", name, "err", err)
+			return nil
+		}
+		defer t.Close()
+
+		if id := t.Identify(); !id.IsGo && id.Conclusive && !o.Force {
+			slog.Debug("Skipping executable not built by Go", "member", name)
+			return nil
+		}
+
+		if found > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s:\n", name)
+		writeCandidateSummary(w, scanTarget(t, o), o.Verbose)
+
+		found++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Executable(s) scanned", "count", found)
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanContainer(t *testing.T) {
+	// a Go executable without section headers, only identified by the build
+	// information found in its segments, and the same executable packed
+	stripped := relroELF()
+	copy(stripped[0x1080:], buildInfo("go1.22.3"))
+	packed := packUPXELF(t, stripped, upxStore)
+
+	// not identified as Go
+	other := relroELF()
+
+	name := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{
+		{"bin/stripped", stripped},
+		{"bin/packed", packed},
+		{"bin/other", other},
+		{"README", []byte("not an executable")},
+	} {
+		w, _ := zw.Create(m.name)
+		w.Write(m.data)
+	}
+	zw.Close()
+	f.Close()
+
+	tests := []struct {
+		force   bool
+		members []string
+	}{
+		{false, []string{"bin/stripped", "bin/packed"}},
+		{true, []string{"bin/stripped", "bin/packed", "bin/other"}},
+	}
+	for _, tt := range tests {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		c, err := DetectContainer(f)
+		if err != nil || c == nil {
+			t.Fatalf("container not detected: %v", err)
+		}
+
+		o := NewOptions()
+		o.Quiet, o.Force = true, tt.force
+
+		var b bytes.Buffer
+		if err := scanContainer(&b, o, c); err != nil {
+			t.Fatal(err)
+		}
+
+		scanned := []string{}
+		for _, line := range strings.Split(b.String(), "\n") {
+			if strings.HasSuffix(line, ":") {
+				scanned = append(scanned, strings.TrimSuffix(line, ":"))
+			}
+		}
+		if strings.Join(scanned, " ") != strings.Join(tt.members, " ") {
+			t.Errorf("force %v: scanned %v, want %v", tt.force, scanned, tt.members)
+		}
+		if n := strings.Count(b.String(), "2 files"); n != len(tt.members) {
+			t.Errorf("force %v: found %d embed tables, want %d:\n%s", tt.force, n, len(tt.members), b.String())
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+var (
+	// malformed cpio header
+	errCpioHeader = "malformed cpio header"
+)
+
+const (
+	// "newc" and "crc" portable ASCII formats, used by rpm payloads
+	CPIO_NEWC_MAGIC = "070701"
+	CPIO_CRC_MAGIC  = "070702"
+	CPIO_TRAILER    = "TRAILER!!!"
+
+	CPIO_HEADER_SIZE = 110
+	// PATH_MAX, longer names are not produced by cpio or rpmbuild
+	CPIO_MAX_NAME_SIZE = 4096
+	CPIO_TYPE_MASK     = 0170000
+	CPIO_TYPE_REG      = 0100000
+)
+
+// CpioHeader describes a member of a cpio archive
+type CpioHeader struct {
+	Name string
+	Mode int64
+	Size int64
+}
+
+// Regular reports whether the member is a regular file
+func (h *CpioHeader) Regular() bool {
+	return h.Mode&CPIO_TYPE_MASK == CPIO_TYPE_REG
+}
+
+// CpioReader reads the members of a cpio archive in the "newc" format
+// sequentially, the same way archive/tar does.
+type CpioReader struct {
+	r    io.Reader
+	size int64 // length of the archive
+	next int64 // offset of the next header
+
+	remaining int64 // unread bytes of the current member
+	pad       int64 // padding following the current member
+}
+
+// NewCpioReader reads the archive from r, which holds at most size bytes
+func NewCpioReader(r io.Reader, size int64) *CpioReader {
+	return &CpioReader{r: r, size: size}
+}
+
+// align4 returns the padding required to align n to 4 bytes
+func align4(n int64) int64 {
+	return (4 - n%4) % 4
+}
+
+// Next advances to the next member, io.EOF is returned at the trailer
+func (c *CpioReader) Next() (*CpioHeader, error) {
+	if _, err := io.CopyN(io.Discard, c.r, c.remaining+c.pad); err != nil {
+		return nil, err
+	}
+	c.remaining, c.pad = 0, 0
+
+	hdr := make([]byte, CPIO_HEADER_SIZE)
+	if _, err := io.ReadFull(c.r, hdr); err != nil {
+		return nil, err
+	}
+	if magic := string(hdr[:6]); magic != CPIO_NEWC_MAGIC && magic != CPIO_CRC_MAGIC {
+		return nil, fmt.Errorf(errCpioHeader)
+	}
+
+	// the fields following the magic are 8 hex digits each
+	field := func(i int) (int64, error) {
+		return strconv.ParseInt(string(hdr[6+i*8:6+(i+1)*8]), 16, 64)
+	}
+	mode, err := field(1)
+	if err != nil {
+		return nil, fmt.Errorf(errCpioHeader)
+	}
+	size, err := field(6)
+	if err != nil {
+		return nil, fmt.Errorf(errCpioHeader)
+	}
+	namesize, err := field(11)
+	if err != nil || namesize <= 0 || namesize > CPIO_MAX_NAME_SIZE {
+		return nil, fmt.Errorf(errCpioHeader)
+	}
+
+	name := make([]byte, namesize+align4(CPIO_HEADER_SIZE+namesize))
+	if _, err := io.ReadFull(c.r, name); err != nil {
+		return nil, err
+	}
+
+	// the contents must fit in the rest of the archive
+	c.next += CPIO_HEADER_SIZE + int64(len(name))
+	if size > c.size-c.next {
+		return nil, fmt.Errorf(errCpioHeader)
+	}
+	c.next += size + align4(size)
+
+	h := &CpioHeader{Name: string(name[:namesize-1]), Mode: mode, Size: size}
+	if h.Name == CPIO_TRAILER {
+		return nil, io.EOF
+	}
+
+	c.remaining, c.pad = size, align4(size)
+	return h, nil
+}
+
+// Read reads from the contents of the current member
+func (c *CpioReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// cpioMember is a member of a test archive, with its name size overridden
+// when namesize is set
+type cpioMember struct {
+	name     string
+	mode     int64
+	data     string
+	namesize int64
+}
+
+// testCpio returns a "newc" archive of the members, terminated by a trailer
+func testCpio(members ...cpioMember) []byte {
+	var b bytes.Buffer
+	for _, m := range append(members, cpioMember{name: CPIO_TRAILER}) {
+		namesize := int64(len(m.name) + 1)
+		if m.namesize != 0 {
+			namesize = m.namesize
+		}
+		fmt.Fprintf(&b, "%s%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			CPIO_NEWC_MAGIC, 0, m.mode, 0, 0, 1, 0, len(m.data), 0, 0, 0, 0, namesize, 0)
+		b.WriteString(m.name + "\x00")
+		b.Write(make([]byte, align4(CPIO_HEADER_SIZE+int64(len(m.name)+1))))
+		b.WriteString(m.data)
+		b.Write(make([]byte, align4(int64(len(m.data)))))
+	}
+	return b.Bytes()
+}
+
+func TestCpioReader(t *testing.T) {
+	archive := testCpio(
+		cpioMember{name: "usr/bin", mode: 040755},
+		cpioMember{name: "usr/bin/tool", mode: 0100755, data: "hello"},
+		cpioMember{name: "usr/lib/tool.so", mode: 0100644, data: "world!"},
+	)
+	cr := NewCpioReader(bytes.NewReader(archive), int64(len(archive)))
+
+	want := []string{"usr/bin", "usr/bin/tool hello", "usr/lib/tool.so world!"}
+	got := []string{}
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.Regular() {
+			// contents of skipped members are discarded by Next
+			got = append(got, hdr.Name)
+			continue
+		}
+		data, err := io.ReadAll(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, hdr.Name+" "+string(data))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got members %q, want %q", got, want)
+	}
+}
+
+func TestCpioReaderBounds(t *testing.T) {
+	tests := []struct {
+		name string
+		m    cpioMember
+	}{
+		{"empty name", cpioMember{name: "a", namesize: -1}},
+		{"long name", cpioMember{name: "a", namesize: CPIO_MAX_NAME_SIZE + 1}},
+		{"name past the end", cpioMember{name: "a", namesize: 0x1000}},
+	}
+	for _, tt := range tests {
+		archive := testCpio(tt.m)
+		if _, err := NewCpioReader(bytes.NewReader(archive), int64(len(archive))).Next(); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	// the header and name of the member, followed by 5 bytes of contents
+	archive := testCpio(cpioMember{name: "a", mode: 0100644, data: "hello"})
+	const contents = CPIO_HEADER_SIZE + 2
+
+	// contents larger than the rest of the archive
+	if _, err := NewCpioReader(bytes.NewReader(archive), contents+4).Next(); err == nil {
+		t.Error("member past the end: no error")
+	}
+
+	// a stream cut short, as by a truncated compressed payload
+	cr := NewCpioReader(bytes.NewReader(archive[:contents+2]), MAX_INPUT_SIZE)
+	if _, err := cr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(cr); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("truncated contents: got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := cr.Next(); err == nil {
+		t.Error("truncated archive: no error")
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"cmp"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	DEFAULT_STORE_DIR string = ".gorip-objects"
+)
+
+// DupGroup is a set of entries that share the same key, either the notsha256
+// hash of their contents or the address of their data.
+type DupGroup struct {
+	Key     string
+	Entries []*FSCEntry
+}
+
+// Wasted returns the number of bytes that would be saved if every entry in the
+// group was stored once. Entries sharing an address may differ in size, the
+// largest one holds the data of the others.
+func (g *DupGroup) Wasted() uint64 {
+	total, largest := uint64(0), uint64(0)
+	for _, e := range g.Entries {
+		total += e.Data.Size
+		largest = max(largest, e.Data.Size)
+	}
+	return total - largest
+}
+
+// groupEntries buckets non-directory entries using key and returns every bucket
+// holding more than one entry, ordered by key and named using name.
+func groupEntries[K cmp.Ordered](entries []*FSCEntry, key func(e *FSCEntry) K, name func(k K) string) []*DupGroup {
+	buckets := map[K]*DupGroup{}
+	keys := []K{}
+
+	for _, e := range entries {
+		// directories all share a zero hash and a nil data pointer
+		if e.IsDir {
+			continue
+		}
+
+		k := key(e)
+		g, exists := buckets[k]
+		if !exists {
+			g = &DupGroup{Key: name(k)}
+			buckets[k] = g
+			keys = append(keys, k)
+		}
+		g.Entries = append(g.Entries, e)
+	}
+
+	slices.Sort(keys)
+
+	groups := []*DupGroup{}
+	for _, k := range keys {
+		if g := buckets[k]; len(g.Entries) > 1 {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// Groups entries with identical notsha256 hashes
+func DuplicatesByHash(entries []*FSCEntry) []*DupGroup {
+	return groupEntries(entries, func(e *FSCEntry) string {
+		return hex.EncodeToString(e.Hash[:])
+	}, func(k string) string {
+		return k
+	})
+}
+
+// Groups entries whose data is located at the same address within the section
+func DuplicatesByAddr(entries []*FSCEntry) []*DupGroup {
+	return groupEntries(entries, func(e *FSCEntry) uint64 {
+		return e.Data.Addr
+	}, func(k uint64) string {
+		return fmt.Sprintf("%#x", k)
+	})
+}
+
+// Output the duplicate groups of a candidate in the manifest format
+func writeDuplicateGroups(writer io.Writer, title string, groups []*DupGroup) {
+	wasted := uint64(0)
+	for _, g := range groups {
+		wasted += g.Wasted()
+	}
+
+	fmt.Fprintf(writer, "[+] Duplicates by %s: %d group(s) %d redundant (bytes)\n", title, len(groups), wasted)
+	for _, g := range groups {
+		fmt.Fprintf(writer, "    %s (%d entries)\n", g.Key, len(g.Entries))
+		for _, e := range g.Entries {
+			fmt.Fprintf(writer, "      %s (%d bytes)\n", e.Name, e.Data.Size)
+		}
+	}
+}
+
+// objectPath returns the location of a blob inside the content-addressed store.
+// Blobs are fanned out into subdirectories using the first byte of their hash.
+func objectPath(store string, hash [16]byte) string {
+	key := hex.EncodeToString(hash[:])
+	return filepath.Join(store, key[:2], key)
+}
+
+// writeObject stores data, the contents of an entry with the given hash, in the
+// content-addressed store if it is not already present. Returns the path of the
+// stored object.
+func writeObject(store string, hash [16]byte, data []byte) (string, error) {
+	obj := objectPath(store, hash)
+
+	if _, err := os.Stat(obj); err == nil {
+		return obj, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(obj, data, 0644); err != nil {
+		return "", err
+	}
+
+	return obj, nil
+}
+
+// linkObject points the named path at a stored object. Hard links are used when
+// possible, symbolic links are used as a fallback (e.g. the store is located on
+// a different device).
+func linkObject(obj, name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Link(obj, name); err == nil {
+		return nil
+	}
+
+	abs, err := filepath.Abs(obj)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(abs, name)
+}
+
+// Extract candidates writing every unique blob once into the content-addressed
+// store located at `store`, and linking the named paths to it. Entries whose
+// contents do not match their hash are extracted without the store. Returns the
+// number of bytes extracted.
+func extractCandidatesDedup(candidates []*FSCandidate, store string, filter *EntryFilter, progress *Progress) (uint64, error) {
+	written := map[[16]byte]bool{}
+	saved := uint64(0)
+	total := uint64(0)
+
+	for _, candidate := range candidates {
+		for _, entry := range candidate.Entries() {
+			if !filter.Match(entry) {
+				continue
+			}
+
+			// damaged entries can not be addressed by their hash
+			if entry.IsDir || entry.Damage != "" || !validEntryName(entry.Name) {
+				if err := extractEntry(entry); err != nil {
+					return total, err
+				}
+				if !entry.IsDir && !entry.Unreadable() {
+					total += entry.Data.Size
+					progress.Add(entry.Data.Size)
+				}
+				continue
+			}
+
+			data, err := entry.Read()
+			if err != nil {
+				return total, err
+			}
+			if !entry.VerifyHash(data) {
+				entry.Damage = DAMAGE_HASH
+				if err := extractEntry(entry); err != nil {
+					return total, err
+				}
+				total += entry.Data.Size
+				progress.Add(entry.Data.Size)
+				continue
+			}
+
+			// parent directories may have been filtered out
+			os.MkdirAll(filepath.Dir(entry.Name), 0755)
+
+			obj, err := writeObject(store, entry.Hash, data)
+			if err != nil {
+				return total, err
+			}
+			if written[entry.Hash] {
+				saved += entry.Data.Size
+			}
+			written[entry.Hash] = true
+
+			if err := linkObject(obj, entry.Name); err != nil {
+				return total, err
+			}
+			total += entry.Data.Size
+			progress.Add(entry.Data.Size)
+		}
+	}
+
+	slog.Info("Stored unique objects", "count", len(written), "store", store, "saved", saved)
+	return total, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDuplicatesByAddr(t *testing.T) {
+	entry := func(name string, addr, size uint64) *FSCEntry {
+		return &FSCEntry{Name: name, Data: blob{Addr: addr, Size: size}}
+	}
+	entries := []*FSCEntry{
+		entry("a", 0x10000, 8),
+		entry("b", 0x9000, 4),
+		entry("c", 0x10000, 32),
+		entry("d", 0x9000, 4),
+		entry("e", 0x100, 1),
+		{Name: "dir", IsDir: true},
+		{Name: "dir2", IsDir: true},
+	}
+
+	groups := DuplicatesByAddr(entries)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+
+	// ordered by address, not by the formatted key
+	tests := []struct {
+		key    string
+		n      int
+		wasted uint64
+	}{
+		{"0x9000", 2, 4},
+		{"0x10000", 2, 8},
+	}
+	for i, tt := range tests {
+		g := groups[i]
+		if g.Key != tt.key || len(g.Entries) != tt.n || g.Wasted() != tt.wasted {
+			t.Errorf("group %d: got %s (%d entries, %d wasted), want %s (%d entries, %d wasted)",
+				i, g.Key, len(g.Entries), g.Wasted(), tt.key, tt.n, tt.wasted)
+		}
+	}
+}
+
+func TestExtractCandidatesDedup(t *testing.T) {
+	files := []embedFile{{"a.txt", "same"}, {"b.txt", "same"}, {"c.txt", "other"}}
+	img := make([]byte, 0x200)
+	putEmbedFS(img, binary.LittleEndian, 0x100, 0x1100, 0, 0x1000, files)
+	// the stored hash of c.txt no longer matches its contents
+	img[0x50] = 'O'
+
+	sd := &SectionData{Name: "test", VirtualAddr: 0x1000, VirtualSize: 0x200, FileSize: 0x200, Order: binary.LittleEndian, Ptrsz: 8}
+	sd.Load(img)
+	candidates := findCandidates(sd, DefaultScanOptions())
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	written, err := extractCandidatesDedup(candidates, "store", &EntryFilter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 13 {
+		t.Errorf("wrote %d bytes, want 13", written)
+	}
+
+	for name, want := range map[string]string{"a.txt": "same", "b.txt": "same", "c.txt": "Other"} {
+		if data, err := os.ReadFile(name); err != nil || string(data) != want {
+			t.Errorf("%s: read %q (%v), want %q", name, data, err, want)
+		}
+	}
+
+	// only the contents matching their hash are stored
+	objects, _ := filepath.Glob(filepath.Join("store", "*", "*"))
+	if len(objects) != 1 {
+		t.Errorf("stored %d objects, want 1", len(objects))
+	}
+	if info, err := os.Lstat("c.txt"); err != nil || !info.Mode().IsRegular() {
+		t.Errorf("c.txt is not a regular file")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	// core file has no loaded segments
+	errCoreSegments = "core file has no loaded segments"
+	// invalid pointer size %d, expected 4 or 8
+	errRawPointerSize = "invalid pointer size %d, expected 4 or 8"
+	// invalid byte order \"%s\", expected little or big
+	errRawByteOrder = "invalid byte order \"%s\", expected little or big"
+)
+
+// exeCore is an ELF core dump. Core files have no section headers, the memory
+// image of the process is described by the PT_LOAD segments instead.
+type exeCore struct {
+	f *elf.File
+	r io.ReaderAt
+}
+
+func (x *exeCore) FormatName() string { return "ELF core" }
+
+func (x *exeCore) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, ".rodata")
+}
+
+func (x *exeCore) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+// Sections returns the dumped regions of the address space. Segments adjacent
+// both in memory and in the file, such as the mappings of a single binary, are
+// merged so embed tables may point across them.
+func (x *exeCore) Sections() ([]*SectionData, error) {
+	psize, err := elfPtrsz(x.f)
+	if err != nil {
+		return nil, err
+	}
+
+	sections := []*SectionData{}
+	var last *SectionData
+
+	for _, p := range x.f.Progs {
+		// segments not written to the core only occupy memory
+		if p.Type != elf.PT_LOAD || p.Filesz == 0 {
+			continue
+		}
+
+		if last != nil && last.FileSize == last.VirtualSize &&
+			p.Vaddr == last.VirtualAddr+last.VirtualSize && p.Off == last.FileOffset+last.FileSize {
+			last.VirtualSize += p.Memsz
+			last.FileSize += p.Filesz
+			continue
+		}
+
+		last = &SectionData{
+			Name: fmt.Sprintf("PT_LOAD %#x", p.Vaddr),
+
+			VirtualAddr: p.Vaddr,
+			VirtualSize: p.Memsz,
+			FileOffset:  p.Off,
+			FileSize:    p.Filesz,
+
+			Order: x.f.ByteOrder,
+			Ptrsz: psize,
+		}
+		sections = append(sections, last)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf(errCoreSegments)
+	}
+
+	// the readers are created once merged segments have their final size
+	for _, s := range sections {
+		s.Data = io.NewSectionReader(x.r, int64(s.FileOffset), int64(s.FileSize))
+	}
+	return sections, nil
+}
+
+// RawOptions describes the memory image read in raw mode
+type RawOptions struct {
+	Base  uint64 // virtual address of the first byte of the image
+	Ptrsz int
+	Order string // "little" or "big"
+}
+
+// exeRaw is a raw memory image, such as a region dumped from a debugger or a
+// hypervisor. The whole file is scanned as a single section located at the
+// base address supplied by the user.
+type exeRaw struct {
+	r     io.ReaderAt
+	size  int64
+	order binary.ByteOrder
+	opt   *RawOptions
+}
+
+// NewRawExe validates the raw mode options and describes the image r of size
+// bytes
+func NewRawExe(r io.ReaderAt, size int64, opt *RawOptions) (exe, error) {
+	if opt.Ptrsz != 4 && opt.Ptrsz != 8 {
+		return nil, fmt.Errorf(errRawPointerSize, opt.Ptrsz)
+	}
+
+	var order binary.ByteOrder
+	switch opt.Order {
+	case "little":
+		order = binary.LittleEndian
+	case "big":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf(errRawByteOrder, opt.Order)
+	}
+
+	return &exeRaw{r: r, size: size, order: order, opt: opt}, nil
+}
+
+func (x *exeRaw) FormatName() string { return "raw memory image" }
+
+func (x *exeRaw) Rodata() (*SectionData, error) { return x.SectionData("raw") }
+
+func (x *exeRaw) SectionData(name string) (*SectionData, error) {
+	d := SectionData{
+		Name: name,
+
+		VirtualAddr: x.opt.Base,
+		VirtualSize: uint64(x.size),
+		FileOffset:  0,
+		FileSize:    uint64(x.size),
+
+		Order: x.order,
+		Ptrsz: x.opt.Ptrsz,
+		Data:  io.NewSectionReader(x.r, 0, x.size),
+	}
+
+	return &d, nil
+}
+
+func (x *exeRaw) Sections() ([]*SectionData, error) { return rodataSections(x) }
@@ -1,18 +1,29 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"sort"
 	"strings"
 )
 
+var (
+	// path conflict: %s
+	errPathConflict = "path conflict: %s"
+)
+
 // TreeNode represents a node in the file tree
 type TreeNode struct {
-	Name     string
-	IsDir    bool
-	Entry    *FSCEntry
-	Children map[string]*TreeNode
+	Name  string
+	IsDir bool
+	// Synthesized is set for directories which are implied by the path of an
+	// entry, but have no entry of their own in the embed table
+	Synthesized bool
+	Entry       *FSCEntry
+	Children    map[string]*TreeNode
 }
 
 // FileTree represents the entire file tree
@@ -26,25 +37,46 @@ func NewFileTree() *FileTree {
 		Root: &TreeNode{
 			Name:     "/",
 			IsDir:    true,
-			Entry:    &FSCEntry{},
+			Entry:    &FSCEntry{Name: "./", IsDir: true},
 			Children: make(map[string]*TreeNode),
 		},
 	}
 }
 
-// Insert inserts a new file or directory into the file tree
-func (ft *FileTree) Insert(f *FSCEntry) {
-	components := strings.Split(f.Name, "/")
+// NewCandidateTree creates a file tree holding every entry of a candidate.
+// Entries which could not be inserted are returned as errors.
+func NewCandidateTree(c *FSCandidate) (*FileTree, []error) {
+	tree := NewFileTree()
+	tree.Root.Entry = rootEntry(c.sd)
+
+	var errs []error
+	for _, e := range c.Entries() {
+		if err := tree.Insert(e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return tree, errs
+}
+
+// Insert inserts a new file or directory into the file tree. Missing parent
+// directories are synthesized, and are replaced by their explicit entry if it
+// is inserted later. Inserting a path which already exists, or which passes
+// through a file, is a conflict.
+func (ft *FileTree) Insert(f *FSCEntry) error {
+	components := strings.Split(trimSlash(f.Name), "/")
 	currentNode := ft.Root
 
-	for _, component := range components {
+	for i, component := range components {
 		if component == "" {
 			continue
 		}
+		last := i == len(components)-1
+
 		// check if the component already exists in the current node's children
 		childNode, exists := currentNode.Children[component]
-		if !exists {
-			// if it doesn't exist, create a new node and add it to the current node's children
+		switch {
+		case !exists && last:
 			childNode = &TreeNode{
 				Name:     component,
 				Entry:    f,
@@ -52,46 +84,368 @@ func (ft *FileTree) Insert(f *FSCEntry) {
 				Children: make(map[string]*TreeNode),
 			}
 			currentNode.Children[component] = childNode
+
+		case !exists:
+			// parent directory without an entry (yet), give it its own
+			dir := &FSCEntry{Name: strings.Join(components[:i+1], "/") + "/", IsDir: true, sd: f.sd}
+			childNode = &TreeNode{
+				Name:        component,
+				Entry:       dir,
+				IsDir:       true,
+				Synthesized: true,
+				Children:    make(map[string]*TreeNode),
+			}
+			currentNode.Children[component] = childNode
+
+		case last:
+			if !(childNode.Synthesized && f.IsDir) {
+				return fmt.Errorf(errPathConflict, f.Name)
+			}
+			childNode.Entry = f
+			childNode.Synthesized = false
+
+		case !childNode.IsDir:
+			return fmt.Errorf(errPathConflict, f.Name)
 		}
 
 		currentNode = childNode
 	}
+
+	return nil
 }
 
-// Output the tree
-func PrintTree(node *TreeNode, indent string, writer io.Writer) {
-	fmt.Fprintf(writer, indent+node.Name)
+// PathConflict is a path present in more than one candidate that would collide
+// once the candidates are extracted to the same directory. Directories present
+// in several candidates are merged and are not conflicts.
+type PathConflict struct {
+	Name       string
+	Candidates []int
+	Entries    []*FSCEntry
+}
 
-	if node.IsDir && node.Name != "/" {
-		fmt.Fprintln(writer, "/")
-	} else {
-		fmt.Fprintln(writer)
+// Identical reports whether every conflicting entry is a file with the same
+// contents, in which case extraction is unaffected by which one wins
+func (p *PathConflict) Identical() bool {
+	for _, e := range p.Entries {
+		if e.IsDir || e.Hash != p.Entries[0].Hash {
+			return false
+		}
 	}
+	return true
+}
 
-	for _, child := range node.Children {
-		PrintTree(child, indent+" ", writer)
+// FindPathConflicts returns every path which appears in more than one
+// candidate as anything other than a directory, ordered by path
+func FindPathConflicts(candidates []*FSCandidate) []*PathConflict {
+	paths := map[string]*PathConflict{}
+
+	for ci, c := range candidates {
+		for _, e := range c.Entries() {
+			name := trimSlash(e.Name)
+			p, exists := paths[name]
+			if !exists {
+				p = &PathConflict{Name: name}
+				paths[name] = p
+			}
+			p.Candidates = append(p.Candidates, ci)
+			p.Entries = append(p.Entries, e)
+		}
+	}
+
+	conflicts := []*PathConflict{}
+	for _, p := range paths {
+		if len(p.Entries) < 2 {
+			continue
+		}
+		for _, e := range p.Entries {
+			if !e.IsDir {
+				conflicts = append(conflicts, p)
+				break
+			}
+		}
 	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	return conflicts
 }
 
-// Output the tree sorted in lexicographical order
-func PrintTreeSorted(node *TreeNode, indent string, writer io.Writer) {
-	fmt.Fprintf(writer, "%s%s", indent, node.Name)
+// Output the path conflicts between candidates
+func writePathConflicts(writer io.Writer, conflicts []*PathConflict) {
+	if len(conflicts) == 0 {
+		return
+	}
 
-	if node.IsDir && node.Name != "/" {
-		fmt.Fprintln(writer, "/")
-	} else {
-		fmt.Fprintln(writer)
+	fmt.Fprintf(writer, "[!] Path conflict(s) across candidates: %d\n", len(conflicts))
+	for _, p := range conflicts {
+		state := "differ"
+		if p.Identical() {
+			state = "identical"
+		}
+
+		fmt.Fprintf(writer, "    %s (%s)\n", p.Name, state)
+		for i, e := range p.Entries {
+			if e.IsDir {
+				fmt.Fprintf(writer, "      candidate %d: directory\n", p.Candidates[i])
+			} else {
+				fmt.Fprintf(writer, "      candidate %d: file %d (bytes) %x\n", p.Candidates[i], e.Data.Size, e.Hash)
+			}
+		}
 	}
+}
 
-	var keys []string
-	for key := range node.Children {
-		keys = append(keys, key)
+// Log every path conflict as a warning
+func logPathConflicts(conflicts []*PathConflict) {
+	for _, p := range conflicts {
+		state := "differ"
+		if p.Identical() {
+			state = "identical"
+		}
+		slog.Warn("Path conflict across candidates", "name", p.Name, "state", state, "candidates", fmt.Sprint(p.Candidates))
 	}
+}
+
+// TreeOptions controls how a file tree is rendered, modeled on tree(1)
+type TreeOptions struct {
+	Charset string // connector set, "unicode" or "ascii"
+	Size    bool   // print the size of each file
+	Human   bool   // print sizes in a human readable format (implies Size)
+	DirSize bool   // print aggregate sizes and file counts of directories (implies Size)
+	Hash    bool   // print the notsha256 hash of each file
+	Depth   int    // descend at most Depth levels below the root, 0 for no limit
+	JSON    bool   // output a nested JSON tree instead of text
+	Merge   bool   // merge every candidate into a single root
+}
+
+// connectors used to draw the branches of the tree
+type treeCharset struct {
+	branch, last, pipe, space string
+}
 
+var treeCharsets = map[string]treeCharset{
+	"unicode": {"├── ", "└── ", "│   ", "    "},
+	"ascii":   {"|-- ", "`-- ", "|   ", "    "},
+}
+
+// Returns the children of a node sorted in lexicographical order
+func (n *TreeNode) SortedChildren() []*TreeNode {
+	keys := make([]string, 0, len(n.Children))
+	for key := range n.Children {
+		keys = append(keys, key)
+	}
 	sort.Strings(keys)
 
+	children := make([]*TreeNode, 0, len(keys))
 	for _, key := range keys {
-		child := node.Children[key]
-		PrintTreeSorted(child, indent+" ", writer)
+		children = append(children, n.Children[key])
+	}
+	return children
+}
+
+// Stats returns the aggregate size, file and directory count beneath a node
+func (n *TreeNode) Stats() (size uint64, files, dirs int) {
+	if !n.IsDir {
+		return n.Entry.Data.Size, 1, 0
+	}
+
+	for _, child := range n.Children {
+		s, f, d := child.Stats()
+		size, files, dirs = size+s, files+f, dirs+d
+		if child.IsDir {
+			dirs++
+		}
+	}
+	return size, files, dirs
+}
+
+// humanSize formats a byte count the way `tree -h` does
+func humanSize(n uint64) string {
+	const units = "KMGTPE"
+
+	if n < 1024 {
+		return fmt.Sprintf("%d", n)
+	}
+
+	v := float64(n)
+	i := -1
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if v < 10 {
+		return fmt.Sprintf("%.1f%c", v, units[i])
+	}
+	return fmt.Sprintf("%.0f%c", v, units[i])
+}
+
+func (o *TreeOptions) formatSize(n uint64) string {
+	if o.Human {
+		return fmt.Sprintf("%5s", humanSize(n))
+	}
+	return fmt.Sprintf("%11d", n)
+}
+
+// Returns the bracketed columns printed in front of a node's name
+func (o *TreeOptions) columns(n *TreeNode) string {
+	var cols []string
+
+	// every enabled column is printed for every node, so the names line up
+	if o.Size || o.Human || o.DirSize {
+		if n.IsDir && !o.DirSize {
+			cols = append(cols, strings.Repeat(" ", len(o.formatSize(0))))
+		} else {
+			size, _, _ := n.Stats()
+			cols = append(cols, o.formatSize(size))
+		}
+	}
+	if o.Hash {
+		if n.IsDir {
+			cols = append(cols, strings.Repeat(" ", 32))
+		} else {
+			cols = append(cols, hex.EncodeToString(n.Entry.Hash[:]))
+		}
+	}
+
+	if len(cols) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(cols, " ") + "]  "
+}
+
+// Returns the name of a node as it is displayed
+func (o *TreeOptions) label(n *TreeNode) string {
+	name := n.Name
+	if n.IsDir && n.Name != "/" {
+		name += "/"
+	}
+	if o.DirSize && n.IsDir {
+		_, files, _ := n.Stats()
+		name += fmt.Sprintf(" (%d files)", files)
+	}
+	return name
+}
+
+// RenderTree outputs the tree with connectors between nodes, followed by a
+// summary of the number of directories and files
+func RenderTree(writer io.Writer, root *TreeNode, opt *TreeOptions) error {
+	cs, ok := treeCharsets[opt.Charset]
+	if !ok {
+		return fmt.Errorf("unsupported charset \"%s\"", opt.Charset)
+	}
+
+	var render func(n *TreeNode, prefix string, depth int)
+	render = func(n *TreeNode, prefix string, depth int) {
+		if opt.Depth > 0 && depth >= opt.Depth {
+			return
+		}
+
+		children := n.SortedChildren()
+		for i, child := range children {
+			connector, next := cs.branch, cs.pipe
+			if i == len(children)-1 {
+				connector, next = cs.last, cs.space
+			}
+
+			fmt.Fprintf(writer, "%s%s%s%s\n", prefix, connector, opt.columns(child), opt.label(child))
+			render(child, prefix+next, depth+1)
+		}
+	}
+
+	fmt.Fprintf(writer, "%s%s\n", opt.columns(root), opt.label(root))
+	render(root, "", 0)
+
+	_, files, dirs := root.Stats()
+	fmt.Fprintf(writer, "\n%d directories, %d files\n", dirs, files)
+
+	return nil
+}
+
+// jsonTreeNode is the JSON representation of a TreeNode
+type jsonTreeNode struct {
+	Type     string          `json:"type"`
+	Name     string          `json:"name"`
+	Size     *uint64         `json:"size,omitempty"`
+	Files    *int            `json:"files,omitempty"`
+	Hash     string          `json:"hash,omitempty"`
+	Contents []*jsonTreeNode `json:"contents,omitempty"`
+}
+
+func (o *TreeOptions) jsonNode(n *TreeNode, depth int) *jsonTreeNode {
+	j := &jsonTreeNode{Type: "file", Name: n.Name}
+
+	size, files, _ := n.Stats()
+	if !n.IsDir {
+		j.Size = &size
+		if o.Hash {
+			j.Hash = hex.EncodeToString(n.Entry.Hash[:])
+		}
+		return j
+	}
+
+	j.Type = "directory"
+	if o.DirSize {
+		j.Size, j.Files = &size, &files
+	}
+	if o.Depth > 0 && depth >= o.Depth {
+		return j
+	}
+
+	for _, child := range n.SortedChildren() {
+		j.Contents = append(j.Contents, o.jsonNode(child, depth+1))
+	}
+	return j
+}
+
+// RenderTreeJSON outputs the tree as nested JSON objects
+func RenderTreeJSON(writer io.Writer, root *TreeNode, opt *TreeOptions) error {
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "  ")
+	return enc.Encode(opt.jsonNode(root, 0))
+}
+
+type jsonCandidateTree struct {
+	Index int           `json:"index"`
+	Addr  string        `json:"addr"`
+	Tree  *jsonTreeNode `json:"tree"`
+}
+
+type jsonPathConflict struct {
+	Name       string `json:"name"`
+	Identical  bool   `json:"identical"`
+	Candidates []int  `json:"candidates"`
+}
+
+type jsonTreeReport struct {
+	Candidates []*jsonCandidateTree `json:"candidates,omitempty"`
+	Tree       *jsonTreeNode        `json:"tree,omitempty"`
+	Conflicts  []*jsonPathConflict  `json:"conflicts"`
+}
+
+// RenderTreeReportJSON outputs the trees of every candidate, or the merged tree
+// when opt.Merge is set, along with the path conflicts between candidates
+func RenderTreeReportJSON(writer io.Writer, candidates []*FSCandidate, trees []*FileTree, conflicts []*PathConflict, opt *TreeOptions) error {
+	report := jsonTreeReport{Conflicts: []*jsonPathConflict{}}
+
+	if opt.Merge {
+		report.Tree = opt.jsonNode(trees[0].Root, 0)
+	} else {
+		for i, tree := range trees {
+			report.Candidates = append(report.Candidates, &jsonCandidateTree{
+				Index: i,
+				Addr:  fmt.Sprintf("%#x", candidates[i].Addr),
+				Tree:  opt.jsonNode(tree.Root, 0),
+			})
+		}
 	}
+
+	for _, p := range conflicts {
+		report.Conflicts = append(report.Conflicts, &jsonPathConflict{
+			Name:       p.Name,
+			Identical:  p.Identical(),
+			Candidates: p.Candidates,
+		})
+	}
+
+	enc := json.NewEncoder(writer)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
 }
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRenderTreeColumns(t *testing.T) {
+	root := &TreeNode{Name: "/", IsDir: true, Children: map[string]*TreeNode{
+		"bin": {Name: "bin", IsDir: true, Children: map[string]*TreeNode{
+			"sh": {Name: "sh", Entry: &FSCEntry{Name: "bin/sh", Data: blob{Size: 1200}}},
+		}},
+		"motd": {Name: "motd", Entry: &FSCEntry{Name: "motd", Data: blob{Size: 34}}},
+	}}
+
+	tests := []struct {
+		name string
+		opt  TreeOptions
+	}{
+		{"size", TreeOptions{Size: true}},
+		{"du", TreeOptions{DirSize: true}},
+		{"human du", TreeOptions{Human: true, DirSize: true}},
+		{"hash", TreeOptions{Hash: true}},
+		{"size hash", TreeOptions{Size: true, Hash: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			tt.opt.Charset = "unicode"
+			if err := RenderTree(&b, root, &tt.opt); err != nil {
+				t.Fatal(err)
+			}
+
+			// every node prints the same columns, of the same width
+			width := -1
+			for _, line := range strings.Split(b.String(), "\n") {
+				if line == "" || strings.Contains(line, "directories,") {
+					continue
+				}
+				i := strings.Index(line, "]  ")
+				if i < 0 {
+					t.Fatalf("line %q has no columns", line)
+				}
+				w := len([]rune(line[strings.Index(line, "["):i]))
+				if width >= 0 && w != width {
+					t.Fatalf("columns misaligned:\n%s", b.String())
+				}
+				width = w
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// patternList is a repeatable command line flag holding path.Match patterns
+type patternList []string
+
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patternList) Set(v string) error {
+	if _, err := path.Match(v, ""); err != nil {
+		return err
+	}
+	*p = append(*p, v)
+	return nil
+}
+
+// EntryFilter selects entries by name using include and exclude patterns
+type EntryFilter struct {
+	Include patternList
+	Exclude patternList
+}
+
+// matchAny reports whether a pattern matches name, its base name, or one of
+// its parent directories. Matching parents allows `assets/gfx` to select
+// everything beneath that directory.
+func matchAny(patterns []string, name string) bool {
+	name = trimSlash(name)
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, path.Base(name)); ok {
+			return true
+		}
+		for n := name; n != "." && n != "/" && n != ""; n = path.Dir(n) {
+			if ok, _ := path.Match(p, n); ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Match reports whether an entry passes the filter. Entries are selected when
+// they match at least one include pattern (or no include patterns were given)
+// and do not match any exclude pattern.
+func (f *EntryFilter) Match(e *FSCEntry) bool {
+	if len(f.Include) > 0 && !matchAny(f.Include, e.Name) {
+		return false
+	}
+	return !matchAny(f.Exclude, e.Name)
+}
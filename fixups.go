@@ -0,0 +1,216 @@
+package main
+
+import (
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+var (
+	// malformed chained fixups: %s
+	errChainedFixups = "malformed chained fixups: %s"
+)
+
+const (
+	LC_DYLD_CHAINED_FIXUPS = 0x80000034
+
+	// reference: mach-o/fixup-chains.h
+	DYLD_CHAINED_PTR_ARM64E            = 1
+	DYLD_CHAINED_PTR_64                = 2
+	DYLD_CHAINED_PTR_64_OFFSET         = 6
+	DYLD_CHAINED_PTR_ARM64E_USERLAND   = 9
+	DYLD_CHAINED_PTR_ARM64E_USERLAND24 = 12
+
+	DYLD_CHAINED_PTR_START_NONE = 0xffff
+)
+
+// Sections returns the read-only data of the executable. Pointers of the
+// read-only data are placed in __DATA_CONST when the executable is position
+// independent, so __TEXT,__rodata is scanned along with the following
+// segments up to the end of __DATA_CONST when the file maps them linearly.
+// Executables linked with LC_DYLD_CHAINED_FIXUPS store pointers as fixup
+// records, which are decoded to the addresses they are rebased to. Classic
+// LC_DYLD_INFO rebases store the unslid addresses in place, so their pointers
+// are read as they are.
+func (x *exeMACHO) Sections() ([]*SectionData, error) {
+	sd, err := x.Rodata()
+	if err != nil {
+		return nil, err
+	}
+
+	seg := x.f.Segment("__DATA_CONST")
+	if seg == nil || seg.Addr < sd.VirtualAddr+sd.VirtualSize || seg.Addr-seg.Offset != sd.VirtualAddr-sd.FileOffset {
+		return []*SectionData{sd}, nil
+	}
+
+	sd.Name = "__rodata-__DATA_CONST"
+	sd.VirtualSize = seg.Addr + seg.Filesz - sd.VirtualAddr
+	sd.FileSize = sd.VirtualSize
+	sd.Data = io.NewSectionReader(x.r, int64(sd.FileOffset), int64(sd.FileSize))
+
+	fixups := x.chainedFixups()
+	if fixups == nil {
+		return []*SectionData{sd}, nil
+	}
+
+	memory := make([]byte, sd.FileSize)
+	if _, err := x.r.ReadAt(memory, int64(sd.FileOffset)); err != nil {
+		return nil, err
+	}
+	n, err := x.applyChainedFixups(fixups, memory, sd.FileOffset)
+	if err != nil {
+		return nil, err
+	}
+	slog.Debug("Decoded chained fixups", "count", n)
+
+	sd.Load(memory)
+	return []*SectionData{sd}, nil
+}
+
+// chainedFixups returns the contents of LC_DYLD_CHAINED_FIXUPS, if present
+func (x *exeMACHO) chainedFixups() []byte {
+	for _, l := range x.f.Loads {
+		raw := l.Raw()
+		if len(raw) < 16 || x.f.ByteOrder.Uint32(raw) != LC_DYLD_CHAINED_FIXUPS {
+			continue
+		}
+
+		// linkedit_data_command
+		off := x.f.ByteOrder.Uint32(raw[8:12])
+		size := x.f.ByteOrder.Uint32(raw[12:16])
+
+		data := make([]byte, size)
+		if _, err := x.r.ReadAt(data, int64(off)); err != nil {
+			slog.Warn("Ignoring unreadable chained fixups", "err", err)
+			return nil
+		}
+		return data
+	}
+	return nil
+}
+
+// applyChainedFixups walks the fixup chains of every page located in memory,
+// which holds the file contents starting at offset, and replaces rebases with
+// their target address. Binds are resolved at load time and replaced with 0.
+// Returns the number of decoded pointers.
+func (x *exeMACHO) applyChainedFixups(fixups, memory []byte, offset uint64) (int, error) {
+	order := binary.LittleEndian
+	if len(fixups) < 28 {
+		return 0, fmt.Errorf(errChainedFixups, "header")
+	}
+
+	// dyld_chained_fixups_header.starts_offset
+	starts := uint64(order.Uint32(fixups[4:8]))
+	if starts+4 > uint64(len(fixups)) {
+		return 0, fmt.Errorf(errChainedFixups, "starts in image")
+	}
+	count := uint64(order.Uint32(fixups[starts:]))
+	if starts+4+count*4 > uint64(len(fixups)) {
+		return 0, fmt.Errorf(errChainedFixups, "starts in image")
+	}
+
+	// the preferred load address, which offset based formats are relative to
+	base := uint64(0)
+	if text := x.f.Segment("__TEXT"); text != nil {
+		base = text.Addr
+	}
+
+	// segment indexes are those of the segment load commands
+	segments := []uint64{}
+	for _, l := range x.f.Loads {
+		if s, ok := l.(*macho.Segment); ok {
+			segments = append(segments, s.Offset)
+		}
+	}
+
+	decoded := 0
+	for i := uint64(0); i < count && i < uint64(len(segments)); i++ {
+		info := uint64(order.Uint32(fixups[starts+4+i*4:]))
+		if info == 0 {
+			continue
+		}
+
+		// dyld_chained_starts_in_segment
+		s := starts + info
+		if s+22 > uint64(len(fixups)) {
+			return 0, fmt.Errorf(errChainedFixups, "starts in segment")
+		}
+		pageSize := uint64(order.Uint16(fixups[s+4:]))
+		format := order.Uint16(fixups[s+6:])
+		pages := uint64(order.Uint16(fixups[s+20:]))
+		if s+22+pages*2 > uint64(len(fixups)) {
+			return 0, fmt.Errorf(errChainedFixups, "page starts")
+		}
+
+		for p := uint64(0); p < pages; p++ {
+			start := uint64(order.Uint16(fixups[s+22+p*2:]))
+			if start == DYLD_CHAINED_PTR_START_NONE {
+				continue
+			}
+
+			loc := segments[i] + p*pageSize + start
+			if loc < offset || loc >= offset+uint64(len(memory)) {
+				continue
+			}
+			n, err := decodeChain(memory, loc-offset, format, base)
+			if err != nil {
+				return 0, err
+			}
+			decoded += n
+		}
+	}
+
+	return decoded, nil
+}
+
+// decodeChain decodes the chain of pointers starting at memory[at]
+func decodeChain(memory []byte, at uint64, format uint16, base uint64) (int, error) {
+	order := binary.LittleEndian
+	decoded := 0
+
+	for {
+		if at+8 > uint64(len(memory)) {
+			return decoded, nil
+		}
+		v := order.Uint64(memory[at:])
+
+		var value, next, stride uint64
+		switch format {
+		case DYLD_CHAINED_PTR_64, DYLD_CHAINED_PTR_64_OFFSET:
+			next, stride = (v>>51)&0xfff, 4
+			if v>>63 == 0 {
+				// rebase: target:36 high8:8
+				value = (v>>36&0xff)<<56 | v&(1<<36-1)
+				if format == DYLD_CHAINED_PTR_64_OFFSET {
+					value += base
+				}
+			}
+		case DYLD_CHAINED_PTR_ARM64E, DYLD_CHAINED_PTR_ARM64E_USERLAND, DYLD_CHAINED_PTR_ARM64E_USERLAND24:
+			next, stride = (v>>51)&0x7ff, 8
+			auth, bind := v>>63 == 1, v>>62&1 == 1
+			switch {
+			case bind:
+			case auth:
+				// authenticated rebases hold an offset from the load address
+				value = base + v&0xffffffff
+			default:
+				value = (v>>43&0xff)<<56 | v&(1<<43-1)
+				if format != DYLD_CHAINED_PTR_ARM64E {
+					value += base
+				}
+			}
+		default:
+			return decoded, fmt.Errorf(errChainedFixups, fmt.Sprintf("unsupported pointer format %d", format))
+		}
+
+		order.PutUint64(memory[at:], value)
+		decoded++
+
+		if next == 0 {
+			return decoded, nil
+		}
+		at += next * stride
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// fixup words of mach-o/fixup-chains.h, next is in units of the stride
+func ptr64Rebase(target, high8, next uint64) uint64 { return next<<51 | high8<<36 | target }
+func ptr64Bind(ordinal, next uint64) uint64         { return 1<<63 | next<<51 | ordinal }
+
+func arm64eRebase(target, high8, next uint64) uint64 { return next<<51 | high8<<43 | target }
+func arm64eBind(ordinal, next uint64) uint64         { return 1<<62 | next<<51 | ordinal }
+
+// the diversity, address diversity and key bits are set to check they are masked
+func arm64eAuthRebase(target, next uint64) uint64 {
+	return 1<<63 | next<<51 | 2<<49 | 1<<48 | 0x1234<<32 | target
+}
+func arm64eAuthBind(ordinal, next uint64) uint64 { return 3<<62 | next<<51 | 0x1234<<32 | ordinal }
+
+func TestDecodeChain(t *testing.T) {
+	const base = 0x100000000
+	const skipped = 0xdeadbeef
+
+	tests := []struct {
+		name   string
+		format uint16
+		words  []uint64 // at 8 byte intervals
+		want   []uint64
+	}{
+		{
+			name:   "ptr64",
+			format: DYLD_CHAINED_PTR_64,
+			words:  []uint64{ptr64Rebase(0x100001234, 0, 2), ptr64Rebase(0x100005678, 0x80, 2), ptr64Bind(3, 0)},
+			want:   []uint64{0x100001234, 0x80000001_00005678, 0},
+		},
+		{
+			// a stride of 4 bytes, next 4 skips a word
+			name:   "ptr64 skip",
+			format: DYLD_CHAINED_PTR_64,
+			words:  []uint64{ptr64Rebase(0x100001000, 0, 4), skipped, ptr64Rebase(0x100002000, 0, 0)},
+			want:   []uint64{0x100001000, skipped, 0x100002000},
+		},
+		{
+			name:   "ptr64 offset",
+			format: DYLD_CHAINED_PTR_64_OFFSET,
+			words:  []uint64{ptr64Rebase(0x1234, 0, 2), ptr64Rebase(0x5678, 0x80, 2), ptr64Bind(1, 0)},
+			want:   []uint64{base + 0x1234, 0x80000000_00005678 + base, 0},
+		},
+		{
+			// a stride of 8 bytes, targets are addresses except authenticated ones
+			name:   "arm64e",
+			format: DYLD_CHAINED_PTR_ARM64E,
+			words:  []uint64{arm64eRebase(0x100004000, 0, 1), arm64eAuthRebase(0x4010, 1), arm64eBind(2, 1), arm64eAuthBind(2, 0)},
+			want:   []uint64{0x100004000, base + 0x4010, 0, 0},
+		},
+		{
+			name:   "arm64e skip",
+			format: DYLD_CHAINED_PTR_ARM64E,
+			words:  []uint64{arm64eRebase(0x100004000, 0x80, 2), skipped, arm64eRebase(0x100004008, 0, 0)},
+			want:   []uint64{0x80000001_00004000, skipped, 0x100004008},
+		},
+		{
+			// userland formats hold offsets from the load address
+			name:   "arm64e userland",
+			format: DYLD_CHAINED_PTR_ARM64E_USERLAND,
+			words:  []uint64{arm64eRebase(0x4000, 0, 1), arm64eAuthRebase(0x4010, 1), arm64eBind(2, 0)},
+			want:   []uint64{base + 0x4000, base + 0x4010, 0},
+		},
+		{
+			name:   "arm64e userland24",
+			format: DYLD_CHAINED_PTR_ARM64E_USERLAND24,
+			words:  []uint64{arm64eRebase(0x4000, 0x80, 1), arm64eAuthBind(0x123456, 0)},
+			want:   []uint64{0x80000000_00004000 + base, 0},
+		},
+		{
+			// the chain ends with the memory
+			name:   "truncated",
+			format: DYLD_CHAINED_PTR_64,
+			words:  []uint64{ptr64Rebase(0x100001000, 0, 2), ptr64Rebase(0x100002000, 0, 2)},
+			want:   []uint64{0x100001000, 0x100002000},
+		},
+	}
+
+	for _, tt := range tests {
+		memory := make([]byte, len(tt.words)*8)
+		for i, w := range tt.words {
+			binary.LittleEndian.PutUint64(memory[i*8:], w)
+		}
+
+		n, err := decodeChain(memory, 0, tt.format, base)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		decoded := 0
+		for i, want := range tt.want {
+			if got := binary.LittleEndian.Uint64(memory[i*8:]); got != want {
+				t.Errorf("%s: word %d decoded to %#x, want %#x", tt.name, i, got, want)
+			}
+			if want != skipped {
+				decoded++
+			}
+		}
+		if n != decoded {
+			t.Errorf("%s: %d pointers decoded, want %d", tt.name, n, decoded)
+		}
+	}
+}
+
+func TestDecodeChainUnsupported(t *testing.T) {
+	// DYLD_CHAINED_PTR_32
+	if _, err := decodeChain(make([]byte, 8), 0, 3, 0); err == nil {
+		t.Error("unsupported pointer format decoded without error")
+	}
+}
@@ -5,10 +5,14 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 
 	"debug/elf"
 	"debug/macho"
 	"debug/pe"
+	"debug/plan9obj"
 )
 
 var (
@@ -16,6 +20,8 @@ var (
 	errUnrecognizedFormat = "unrecognized file format"
 	// section \"%s\" does not exist
 	errSectionNonexistent = "section \"%s\" does not exist"
+	// unsupported ELF class %s
+	errELFClass = "unsupported ELF class %s"
 )
 
 func DetectExeFormat(r io.ReaderAt) (exe, error) {
@@ -23,8 +29,7 @@ func DetectExeFormat(r io.ReaderAt) (exe, error) {
 	if n, err := r.ReadAt(ident, 0); n < len(ident) || err != nil {
 		return nil, fmt.Errorf(errUnrecognizedFormat)
 	}
-
-	fmt.Printf("[+] Ident: %x\n", ident)
+	slog.Debug("Read ident", "ident", fmt.Sprintf("%x", ident))
 
 	switch {
 	case bytes.HasPrefix(ident, []byte("MZ")):
@@ -37,7 +42,17 @@ func DetectExeFormat(r io.ReaderAt) (exe, error) {
 	case bytes.HasPrefix(ident, []byte("\x7fELF")):
 		f, err := elf.NewFile(r)
 		if err != nil {
-			panic(err)
+			// retry without the section headers, which may have been corrupted
+			if f, err = elf.NewFile(withoutSectionHeaders(r, ident)); err != nil {
+				return nil, err
+			}
+			slog.Warn("Ignoring malformed ELF section headers")
+		}
+		switch f.Type {
+		case elf.ET_CORE:
+			return &exeCore{f, r}, nil
+		case elf.ET_REL:
+			return &exeELFObject{f, r}, nil
 		}
 		return &exeELF{f}, nil
 
@@ -48,16 +63,63 @@ func DetectExeFormat(r io.ReaderAt) (exe, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &exeMACHO{f}, nil
+		if f.Type == macho.TypeObj {
+			return &exeMachOObject{f, r}, nil
+		}
+		return &exeMACHO{f, r}, nil
+
+	case bytes.HasPrefix(ident, []byte(MINIDUMP_SIGNATURE)):
+		x, err := NewMinidump(r)
+		if err != nil {
+			return nil, err
+		}
+		return x, nil
+
+	case bytes.HasPrefix(ident, []byte(WASM_MAGIC)):
+		x, err := NewWasm(r)
+		if err != nil {
+			return nil, err
+		}
+		return x, nil
+
+	case bytes.HasPrefix(ident, []byte(XCOFF64_MAGIC)):
+		x, err := NewXCOFF(r)
+		if err != nil {
+			return nil, err
+		}
+		return x, nil
+
+	case isPlan9Magic(binary.BigEndian.Uint32(ident)):
+		f, err := plan9obj.NewFile(r)
+		if err != nil {
+			return nil, err
+		}
+		return &exePlan9{f}, nil
 	}
 
 	return nil, fmt.Errorf(errUnrecognizedFormat)
 }
 
+// readerSize returns the length of r when it is a file or an in-memory reader,
+// otherwise math.MaxInt64
+func readerSize(r io.ReaderAt) int64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return r.Size()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if info, err := r.Stat(); err == nil {
+			return info.Size()
+		}
+	}
+	return math.MaxInt64
+}
+
 type exe interface {
 	FormatName() string
 	Rodata() (*SectionData, error)
 	SectionData(x string) (*SectionData, error)
+	// Sections returns the sections scanned for candidates
+	Sections() ([]*SectionData, error)
 }
 
 type exePE struct {
@@ -68,16 +130,36 @@ type exeELF struct {
 }
 type exeMACHO struct {
 	f *macho.File
+	r io.ReaderAt
+}
+type exePlan9 struct {
+	f *plan9obj.File
 }
 
 func (x *exeELF) FormatName() string   { return "ELF" }
 func (x *exePE) FormatName() string    { return "PE" }
 func (x *exeMACHO) FormatName() string { return "MACHO" }
+func (x *exePlan9) FormatName() string { return "Plan 9" }
 
 func (x *exeELF) Rodata() (*SectionData, error)   { return x.SectionData(".rodata") }
 func (x *exePE) Rodata() (*SectionData, error)    { return x.SectionData(".rdata") }
 func (x *exeMACHO) Rodata() (*SectionData, error) { return x.SectionData("__rodata") }
 
+// Plan 9 executables only have text and data segments, read-only data is
+// placed in the text segment
+func (x *exePlan9) Rodata() (*SectionData, error) { return x.SectionData("text") }
+
+func (x *exePlan9) Sections() ([]*SectionData, error) { return rodataSections(x) }
+
+// Executables only need their read-only data section scanned
+func rodataSections(x exe) ([]*SectionData, error) {
+	sd, err := x.Rodata()
+	if err != nil {
+		return nil, err
+	}
+	return []*SectionData{sd}, nil
+}
+
 func (x *exePE) imageBase() uint64 {
 	switch oh := x.f.OptionalHeader.(type) {
 	case *pe.OptionalHeader32:
@@ -94,7 +176,10 @@ func (x *exePE) SectionData(name string) (*SectionData, error) {
 	if s == nil {
 		return nil, fmt.Errorf(errSectionNonexistent, name)
 	}
+	return x.sectionData(s), nil
+}
 
+func (x *exePE) sectionData(s *pe.Section) *SectionData {
 	var psize int
 	switch x.f.FileHeader.Machine {
 	case pe.IMAGE_FILE_MACHINE_I386:
@@ -110,7 +195,7 @@ func (x *exePE) SectionData(name string) (*SectionData, error) {
 	}
 
 	d := SectionData{
-		Name: name,
+		Name: s.Name,
 
 		VirtualAddr: uint64(s.VirtualAddress),
 		VirtualSize: uint64(s.VirtualSize),
@@ -124,7 +209,7 @@ func (x *exePE) SectionData(name string) (*SectionData, error) {
 		Ptrsz: psize,
 	}
 
-	return &d, nil
+	return &d
 }
 
 func (x *exeELF) imageBase() uint64 {
@@ -139,20 +224,26 @@ func (x *exeELF) imageBase() uint64 {
 	// }
 }
 
+// elfPtrsz returns the pointer size of the class of f
+func elfPtrsz(f *elf.File) (int, error) {
+	switch f.Class {
+	case elf.ELFCLASS32:
+		return 4, nil
+	case elf.ELFCLASS64:
+		return 8, nil
+	}
+	return 0, fmt.Errorf(errELFClass, f.Class)
+}
+
 func (x *exeELF) SectionData(name string) (*SectionData, error) {
 	s := x.f.Section(name)
 	if s == nil {
 		return nil, fmt.Errorf(errSectionNonexistent, name)
 	}
 
-	var psize int
-	switch x.f.Class {
-	case elf.ELFCLASS32:
-		psize = 4
-	case elf.ELFCLASS64:
-		psize = 8
-	default:
-		panic(fmt.Errorf("unsupported ELF architecture"))
+	psize, err := elfPtrsz(x.f)
+	if err != nil {
+		return nil, err
 	}
 
 	d := SectionData{
@@ -166,6 +257,7 @@ func (x *exeELF) SectionData(name string) (*SectionData, error) {
 		// compressed, s.Filesize will return the compressed size.
 		FileSize:   s.Size,
 		FileOffset: s.Offset,
+		Compressed: s.Flags&elf.SHF_COMPRESSED != 0,
 
 		Order: x.f.ByteOrder,
 
@@ -202,3 +294,51 @@ func (x *exeMACHO) SectionData(name string) (*SectionData, error) {
 
 	return &d, nil
 }
+
+// isPlan9Magic reports whether magic is the a.out magic of an architecture the
+// Go toolchain targets on Plan 9
+func isPlan9Magic(magic uint32) bool {
+	return magic == plan9obj.Magic386 || magic == plan9obj.MagicAMD64 || magic == plan9obj.MagicARM
+}
+
+// segmentAlign returns the alignment of the data segment, which follows the
+// text segment in memory
+func (x *exePlan9) segmentAlign() uint64 {
+	if x.f.Magic == plan9obj.MagicAMD64 {
+		return 0x200000
+	}
+	return 0x1000
+}
+
+func (x *exePlan9) SectionData(name string) (*SectionData, error) {
+	s := x.f.Section(name)
+	if s == nil {
+		return nil, fmt.Errorf(errSectionNonexistent, name)
+	}
+
+	// the header is loaded along with the text segment
+	addr := x.f.LoadAddress + uint64(s.Offset)
+	if name == "data" {
+		text := x.f.Section("text")
+		align := x.segmentAlign()
+		addr = (x.f.LoadAddress + uint64(text.Offset) + uint64(text.Size) + align - 1) &^ (align - 1)
+	}
+
+	d := SectionData{
+		Name: name,
+
+		VirtualAddr: addr,
+		VirtualSize: uint64(s.Size),
+		BaseAddr:    0,
+
+		FileOffset: uint64(s.Offset),
+		FileSize:   uint64(s.Size),
+
+		// every architecture supported by Go on Plan 9 is little-endian
+		Order: binary.LittleEndian,
+		Ptrsz: x.f.PtrSize,
+		Data:  s.Open(),
+	}
+
+	return &d, nil
+}
@@ -1,3 +1,8 @@
 module github.com/woesbot/gorip
 
-go 1.21.3
+go 1.22
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/ulikunitz/xz v0.5.15
+)
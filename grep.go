@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+const (
+	// number of leading bytes inspected when detecting binary files, same as
+	// GNU grep and git
+	BINARY_SNIFF_SIZE int = 8000
+)
+
+// GrepOptions controls how embedded file contents are searched
+type GrepOptions struct {
+	Pattern *regexp.Regexp
+	Filter  *EntryFilter
+
+	Context int  // lines of context printed around each match
+	Count   bool // only print the number of matching lines per file
+	Text    bool // search binary files as if they were text
+}
+
+// isBinary reports whether data looks like a binary file, using the presence of
+// a NUL byte in the leading bytes as the heuristic
+func isBinary(data []byte) bool {
+	if len(data) > BINARY_SNIFF_SIZE {
+		data = data[:BINARY_SNIFF_SIZE]
+	}
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// grepEntry searches the contents of a single entry, writing matches in the
+// form `candidate:path:line: match`. Context lines use `-` as the separator.
+// Returns the number of matching lines.
+func grepEntry(writer io.Writer, ci int, e *FSCEntry, opt *GrepOptions) (int, error) {
+	data, err := e.Read()
+	if err != nil {
+		return 0, err
+	}
+
+	if !opt.Text && isBinary(data) {
+		if !opt.Pattern.Match(data) {
+			return 0, nil
+		}
+		if opt.Count {
+			fmt.Fprintf(writer, "%d:%s:%d\n", ci, e.Name, 1)
+		} else {
+			fmt.Fprintf(writer, "%d:%s: binary file matches\n", ci, e.Name)
+		}
+		return 1, nil
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+
+	matches := 0
+	printed := -1 // index of the last printed line
+
+	for i, line := range lines {
+		if !opt.Pattern.Match(line) {
+			continue
+		}
+		matches++
+
+		if opt.Count {
+			continue
+		}
+
+		start := max(i-opt.Context, printed+1)
+		if opt.Context > 0 && printed >= 0 && start > printed+1 {
+			fmt.Fprintln(writer, "--")
+		}
+		for j := start; j < i; j++ {
+			fmt.Fprintf(writer, "%d:%s-%d- %s\n", ci, e.Name, j+1, lines[j])
+		}
+
+		fmt.Fprintf(writer, "%d:%s:%d: %s\n", ci, e.Name, i+1, line)
+		printed = i
+
+		// trailing context is printed lazily so overlapping groups merge
+		for j := i + 1; j <= i+opt.Context && j < len(lines); j++ {
+			if opt.Pattern.Match(lines[j]) {
+				break
+			}
+			fmt.Fprintf(writer, "%d:%s-%d- %s\n", ci, e.Name, j+1, lines[j])
+			printed = j
+		}
+	}
+
+	if opt.Count && matches > 0 {
+		fmt.Fprintf(writer, "%d:%s:%d\n", ci, e.Name, matches)
+	}
+
+	return matches, nil
+}
+
+// Search the contents of every file entry of every candidate. Returns the total
+// number of matching lines.
+func grepCandidates(writer io.Writer, candidates []*FSCandidate, opt *GrepOptions) (int, error) {
+	total := 0
+
+	for ci, candidate := range candidates {
+		for _, entry := range candidate.Entries() {
+			if entry.IsDir || entry.Unreadable() || !opt.Filter.Match(entry) {
+				continue
+			}
+
+			n, err := grepEntry(writer, ci, entry, opt)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+)
+
+// The hash stored in an embed table is the first 16 bytes of a SHA-256 variant,
+// which changed between toolchain releases:
+//
+//	go1.16-go1.18  sha256(data)
+//	go1.19-go1.23  cmd/internal/notsha256, ^sha256(data)
+//	go1.24+        cmd/internal/hash, sha256(data) with the first byte inverted
+//	               for files <= 1KB, sha256(0x01 || data) for larger files
+//
+// reference: /src/cmd/compile/internal/staticdata/data.go (fileStringSym)
+func embedHashes(data []byte) [][16]byte {
+	var hashes [][16]byte
+	var h [16]byte
+
+	sum := sha256.Sum256(data)
+	copy(h[:], sum[:])
+	hashes = append(hashes, h)
+
+	for i := range h {
+		h[i] = ^sum[i]
+	}
+	hashes = append(hashes, h)
+
+	copy(h[:], sum[:])
+	h[0] ^= 0xff
+	hashes = append(hashes, h)
+
+	sh := sha256.New()
+	sh.Write([]byte{1})
+	sh.Write(data)
+	copy(h[:], sh.Sum(nil))
+	hashes = append(hashes, h)
+
+	return hashes
+}
+
+// VerifyHash reports whether data matches the hash of the entry under any of
+// the hash algorithms used by the toolchain
+func (f *FSCEntry) VerifyHash(data []byte) bool {
+	for _, h := range embedHashes(data) {
+		if h == f.Hash {
+			return true
+		}
+	}
+	return false
+}
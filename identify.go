@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"debug/buildinfo"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// GoIdentity describes whether a target was built by the Go toolchain
+type GoIdentity struct {
+	IsGo bool
+	// Conclusive is set when the format of the target carries the build
+	// information or the pclntab of Go programs, so a negative result holds
+	Conclusive bool
+	// Version is the exact version from the build information, otherwise the
+	// range of versions using the pclntab format found
+	Version string
+	// Method names the evidence: "buildinfo", "object", "pclntab" or "symbols"
+	Method string
+}
+
+const (
+	// sections without a mapping are searched by chunks, overlapping by enough
+	// to hold the header of the build information and the version
+	IDENTIFY_CHUNK_SIZE    = 1024 * 1024
+	IDENTIFY_CHUNK_OVERLAP = 256
+
+	// the build information stores its strings inline since go1.18
+	BUILDINFO_FLAG_INLINE = 2
+	BUILDINFO_MAX_VERSION = 128
+)
+
+// versions using each pclntab header magic
+// reference: /src/debug/gosym/pclntab.go
+var pclntabVersions = map[uint32]string{
+	0xfffffffb: "go1.2-go1.15",
+	0xfffffffa: "go1.16-go1.17",
+	0xfffffff0: "go1.18-go1.19",
+	0xfffffff1: "go1.20+",
+}
+
+func (id *GoIdentity) String() string {
+	switch {
+	case id.IsGo && id.Version != "":
+		return fmt.Sprintf("yes (%s, %s)", id.Version, id.Method)
+	case id.IsGo:
+		return fmt.Sprintf("yes (unknown version, %s)", id.Method)
+	case id.Conclusive:
+		return "no"
+	}
+	return "unknown"
+}
+
+// identifyGo checks the evidence left by the Go toolchain in x, read from r,
+// from the cheapest to the most expensive: the build information, the header
+// of the pclntab and the symbols of the runtime. The scanned sections are
+// searched for both when the format does not locate them.
+func identifyGo(x exe, r io.ReaderAt, sections []*SectionData) *GoIdentity {
+	id := &GoIdentity{}
+
+	inner := x
+	if u, ok := x.(*exeUPX); ok {
+		inner = u.exe
+	}
+	switch inner.(type) {
+	case *exeELF, *exePE, *exeMACHO, *exeXCOFF, *exePlan9, *exePEImage:
+		id.Conclusive = true
+	}
+
+	if info, err := buildinfo.Read(r); err == nil {
+		id.IsGo, id.Version, id.Method = true, info.GoVersion, "buildinfo"
+		return id
+	}
+	if isGoObject(r) {
+		id.IsGo, id.Method = true, "object"
+		return id
+	}
+
+	// memory images, such as the unpacked image of a UPX packed PE executable,
+	// are not parsed by debug/buildinfo
+	version, magic := searchSections(sections)
+	if version != "" {
+		id.IsGo, id.Version, id.Method = true, version, "buildinfo"
+		return id
+	}
+	if magic == 0 {
+		magic = pclntabMagic(inner)
+	}
+	if magic != 0 {
+		id.IsGo, id.Version, id.Method = true, pclntabVersions[magic], "pclntab"
+		return id
+	}
+
+	// gccgo has no pclntab, its binaries keep the symbols of the runtime
+	if hasRuntimeSymbols(inner) {
+		id.IsGo, id.Method = true, "symbols"
+	}
+	return id
+}
+
+// pclntabMagic returns the magic of the pclntab located by its section
+func pclntabMagic(x exe) uint32 {
+	for _, name := range []string{".gopclntab", "__gopclntab"} {
+		sd, err := x.SectionData(name)
+		if err != nil || sd.FileSize < 8 {
+			continue
+		}
+		hdr := make([]byte, 8)
+		if _, err := io.ReadFull(sd.Data, hdr); err != nil {
+			continue
+		}
+		if magic := findPclntab(hdr, sd.Order); magic != 0 {
+			return magic
+		}
+	}
+	return 0
+}
+
+// searchSections searches the scanned sections for the build information and
+// the pclntab, reading sections without a mapping by chunks. Returns the Go
+// version of the build information and the magic of the first pclntab found.
+func searchSections(sections []*SectionData) (string, uint32) {
+	magic := uint32(0)
+	search := func(data []byte, order binary.ByteOrder) string {
+		if magic == 0 {
+			magic = findPclntab(data, order)
+		}
+		return findBuildInfo(data)
+	}
+
+	parts := []*SectionData{}
+	for _, sd := range sections {
+		parts = append(parts, sd.Parts()...)
+	}
+
+	for _, sd := range parts {
+		order := sd.Order
+		if order == nil {
+			order = binary.LittleEndian
+		}
+		if sd.Bytes != nil {
+			if version := search(sd.Bytes, order); version != "" {
+				return version, magic
+			}
+			continue
+		}
+
+		sd.Reset()
+		buf := make([]byte, IDENTIFY_CHUNK_SIZE)
+		keep := 0
+		for {
+			n, err := io.ReadFull(sd.Data, buf[keep:])
+			chunk := buf[:keep+n]
+			if version := search(chunk, order); version != "" {
+				sd.Reset()
+				return version, magic
+			}
+			if err != nil || len(chunk) < IDENTIFY_CHUNK_OVERLAP {
+				break
+			}
+			keep = copy(buf, chunk[len(chunk)-IDENTIFY_CHUNK_OVERLAP:])
+		}
+		sd.Reset()
+	}
+	return "", magic
+}
+
+// findBuildInfo returns the Go version of the build information found in data.
+// Only the format of go1.18 and later, storing the version inline, is decoded.
+// reference: /src/debug/buildinfo/buildinfo.go
+func findBuildInfo(data []byte) string {
+	for b := data; ; {
+		i := bytes.Index(b, []byte(GO_BUILDINFO_MAGIC))
+		if i < 0 {
+			return ""
+		}
+		// pointer size and flags, the version follows the 32 byte header
+		h := b[i:]
+		if len(h) > 32 && (h[14] == 4 || h[14] == 8) && h[15]&BUILDINFO_FLAG_INLINE != 0 {
+			n, k := binary.Uvarint(h[32:])
+			if k > 0 && n <= BUILDINFO_MAX_VERSION && n <= uint64(len(h)-32-k) {
+				if v := string(h[32+k : 32+k+int(n)]); strings.HasPrefix(v, "go") {
+					return v
+				}
+			}
+		}
+		b = b[i+1:]
+	}
+}
+
+// hasRuntimeSymbols reports whether the symbol table of x defines runtime.main
+func hasRuntimeSymbols(x exe) bool {
+	names := []string{}
+	switch x := x.(type) {
+	case *exeELF:
+		syms, _ := x.f.Symbols()
+		dyn, _ := x.f.DynamicSymbols()
+		for _, s := range append(syms, dyn...) {
+			names = append(names, s.Name)
+		}
+	case *exePE:
+		for _, s := range x.f.Symbols {
+			names = append(names, s.Name)
+		}
+	case *exeMACHO:
+		if x.f.Symtab != nil {
+			for _, s := range x.f.Symtab.Syms {
+				names = append(names, strings.TrimPrefix(s.Name, "_"))
+			}
+		}
+	}
+
+	for _, name := range names {
+		if name == "runtime.main" {
+			return true
+		}
+	}
+	return false
+}
+
+// Identify returns whether the target was built by Go, identified once
+func (t *Target) Identify() *GoIdentity {
+	if t.Go == nil {
+		start := time.Now()
+		t.Go = identifyGo(t.Exe, t.File, t.Sections)
+		t.Timer.Track("identify", start, 0)
+	}
+	return t.Go
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// buildInfo returns the header of the build information of go1.18 and later
+// followed by the inline version
+func buildInfo(version string) []byte {
+	b := append([]byte(GO_BUILDINFO_MAGIC), 8, BUILDINFO_FLAG_INLINE)
+	b = append(b, make([]byte, 32-len(b))...)
+	b = binary.AppendUvarint(b, uint64(len(version)))
+	return append(b, version...)
+}
+
+func TestFindBuildInfo(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"inline", buildInfo("go1.22.3"), "go1.22.3"},
+		{"after a false match", append([]byte(GO_BUILDINFO_MAGIC+"\x00\x00"), buildInfo("go1.21.0")...), "go1.21.0"},
+		{"pointer format", append([]byte(GO_BUILDINFO_MAGIC), append([]byte{8, 0}, make([]byte, 32)...)...), ""},
+		{"truncated", buildInfo("go1.22.3")[:36], ""},
+		{"not a version", buildInfo("devel"), ""},
+		{"none", []byte("no build information"), ""},
+	}
+
+	for _, tt := range tests {
+		if got := findBuildInfo(tt.data); got != tt.want {
+			t.Errorf("%s: found %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSections(t *testing.T) {
+	pclntab := []byte{0xf1, 0xff, 0xff, 0xff, 0, 0, 1, 8}
+
+	// matches straddling the chunks of sections read through their reader, the
+	// second chunk starts IDENTIFY_CHUNK_OVERLAP bytes before the first ends
+	tests := []struct {
+		pclntab, buildinfo int // offsets, -1 when absent
+		version            string
+		magic              uint32
+	}{
+		{0, 64, "go1.22.3", 0xfffffff1},
+		{IDENTIFY_CHUNK_SIZE - 4, -1, "", 0xfffffff1},
+		{2*IDENTIFY_CHUNK_SIZE - IDENTIFY_CHUNK_OVERLAP - 4, -1, "", 0xfffffff1},
+		{-1, IDENTIFY_CHUNK_SIZE - 20, "go1.22.3", 0},
+		{IDENTIFY_CHUNK_SIZE - 4, 2*IDENTIFY_CHUNK_SIZE - IDENTIFY_CHUNK_OVERLAP - 20, "go1.22.3", 0xfffffff1},
+		{-1, -1, "", 0},
+	}
+	for _, tt := range tests {
+		data := make([]byte, 3*IDENTIFY_CHUNK_SIZE)
+		if tt.pclntab >= 0 {
+			copy(data[tt.pclntab:], pclntab)
+		}
+		if tt.buildinfo >= 0 {
+			copy(data[tt.buildinfo:], buildInfo("go1.22.3"))
+		}
+
+		for _, sd := range []*SectionData{
+			{Data: bytes.NewReader(data), Order: binary.LittleEndian},
+			{Bytes: data, Order: binary.LittleEndian},
+		} {
+			version, magic := searchSections([]*SectionData{sd})
+			if version != tt.version || magic != tt.magic {
+				t.Errorf("offsets %#x %#x (mapped %v): found %q and %#x", tt.pclntab, tt.buildinfo, sd.Bytes != nil, version, magic)
+			}
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	// image blob %s does not exist
+	errBlobNonexistent = "image blob %s does not exist"
+	// layer %s: %w
+	errLayer = "layer %s: %w"
+)
+
+const (
+	// prefix of the files marking the removal of a path from the lower layers
+	WHITEOUT_PREFIX = ".wh."
+	// marks a directory whose contents of the lower layers are hidden
+	WHITEOUT_OPAQUE = ".wh..wh..opq"
+)
+
+// isTar reports whether the leading bytes of a file hold a ustar header
+func isTar(head []byte) bool {
+	return len(head) >= 263 && string(head[257:262]) == "ustar"
+}
+
+// Image is a container image saved with `docker save` or stored in an OCI image
+// layout tarball. Only the filesystem resulting from applying every layer is
+// visible, files removed or hidden by whiteouts of upper layers are skipped.
+type Image struct {
+	// Images lists the images of the tarball, most tarballs hold a single one
+	Images []*imageConfig
+
+	blobs map[string]*io.SectionReader
+}
+
+type imageConfig struct {
+	Name   string
+	Layers []string // paths of the layer blobs from the lowest layer up
+}
+
+// docker save manifest.json
+type dockerManifest struct {
+	RepoTags []string
+	Layers   []string
+}
+
+// OCI image index and manifest, reference: image-spec/image-index.md
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+type ociManifest struct {
+	Manifests []ociDescriptor `json:"manifests"` // image index
+	Layers    []ociDescriptor `json:"layers"`    // image manifest
+}
+
+// detectImage indexes the members of the tarball f and parses its manifest.
+// Returns nil if the tarball is not a container image.
+func detectImage(f *os.File) (Container, error) {
+	img := &Image{blobs: map[string]*io.SectionReader{}}
+
+	// archive/tar does not read ahead of the current member, so the position of
+	// the underlying reader is the offset of the member contents
+	sr := io.NewSectionReader(f, 0, 1<<62)
+	tr := tar.NewReader(sr)
+	links := map[string]string{}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeReg:
+			offset, _ := sr.Seek(0, io.SeekCurrent)
+			img.blobs[name] = io.NewSectionReader(f, offset, hdr.Size)
+		case tar.TypeSymlink:
+			// recent versions of docker save link layer.tar to the OCI blobs
+			links[name] = path.Join(path.Dir(name), hdr.Linkname)
+		case tar.TypeLink:
+			links[name] = path.Clean(hdr.Linkname)
+		}
+	}
+	for name, target := range links {
+		if b, ok := img.blobs[target]; ok {
+			img.blobs[name] = b
+		}
+	}
+
+	var err error
+	switch {
+	case img.blobs["manifest.json"] != nil:
+		err = img.parseDockerManifest()
+	case img.blobs["index.json"] != nil:
+		err = img.parseOCIIndex("index.json", "")
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+func (img *Image) FormatName() string { return "container image" }
+
+// decode unmarshals the JSON blob `name`
+func (img *Image) decode(name string, v any) error {
+	b, ok := img.blobs[name]
+	if !ok {
+		return fmt.Errorf(errBlobNonexistent, name)
+	}
+	return json.NewDecoder(io.NewSectionReader(b, 0, b.Size())).Decode(v)
+}
+
+func (img *Image) parseDockerManifest() error {
+	var manifests []dockerManifest
+	if err := img.decode("manifest.json", &manifests); err != nil {
+		return err
+	}
+
+	for i, m := range manifests {
+		name := fmt.Sprintf("image-%d", i)
+		if len(m.RepoTags) > 0 {
+			name = m.RepoTags[0]
+		}
+		img.Images = append(img.Images, &imageConfig{Name: name, Layers: m.Layers})
+	}
+
+	return nil
+}
+
+// blobPath returns the location of a blob inside an OCI image layout
+func blobPath(digest string) string {
+	return path.Join("blobs", strings.Replace(digest, ":", "/", 1))
+}
+
+// parseOCIIndex parses an image index, descending into the nested indexes of
+// multi-platform images
+func (img *Image) parseOCIIndex(name, label string) error {
+	var m ociManifest
+	if err := img.decode(name, &m); err != nil {
+		return err
+	}
+
+	if len(m.Manifests) == 0 {
+		layers := []string{}
+		for _, l := range m.Layers {
+			layers = append(layers, blobPath(l.Digest))
+		}
+		img.Images = append(img.Images, &imageConfig{Name: label, Layers: layers})
+		return nil
+	}
+
+	for _, d := range m.Manifests {
+		l := label
+		if ref, ok := d.Annotations["org.opencontainers.image.ref.name"]; ok {
+			l = ref
+		}
+		if p := d.Platform; p != nil && p.OS != "unknown" {
+			l = strings.TrimSuffix(fmt.Sprintf("%s %s/%s/%s", l, p.OS, p.Architecture, p.Variant), "/")
+		}
+		if l == "" {
+			l = d.Digest
+		}
+
+		// attestation manifests do not describe a filesystem
+		if d.Annotations["vnd.docker.reference.type"] == "attestation-manifest" {
+			continue
+		}
+		if err := img.parseOCIIndex(blobPath(d.Digest), strings.TrimSpace(l)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openLayer returns the uncompressed tar stream of a layer blob. Compressed
+// layers are decompressed once into a spooled file, which is read by both the
+// flattening and the walk of the image.
+func (img *Image) openLayer(name string) (*io.SectionReader, func(), error) {
+	b, ok := img.blobs[name]
+	if !ok {
+		return nil, nil, fmt.Errorf(errBlobNonexistent, name)
+	}
+
+	r, c, err := decompressReader(io.NewSectionReader(b, 0, b.Size()))
+	if err != nil {
+		return nil, nil, fmt.Errorf(errLayer, name, err)
+	}
+	defer r.Close()
+	if c == nil {
+		return io.NewSectionReader(b, 0, b.Size()), func() {}, nil
+	}
+
+	f, err := spool(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf(errLayer, name, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return io.NewSectionReader(f, 0, info.Size()), func() { f.Close() }, nil
+}
+
+// walkLayer calls fn for every member of the uncompressed layer r
+func walkLayer(name string, r *io.SectionReader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	tr := tar.NewReader(io.NewSectionReader(r, 0, r.Size()))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf(errLayer, name, err)
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// layerFile locates the member holding the contents of a regular file
+type layerFile struct {
+	Layer int
+	Name  string
+	Copy  int // a layer may store a path several times, the last copy is used
+}
+
+// layerNode is a path of the filesystem built by applying the layers. Regular
+// files and hard links point to the member holding their contents.
+type layerNode struct {
+	Layer    int // upper layer providing the path
+	Dir      bool
+	File     *layerFile
+	Children map[string]*layerNode
+}
+
+// lookup returns the node of name, creating it and its parent directories in
+// layer when create is set
+func (n *layerNode) lookup(name string, layer int, create bool) *layerNode {
+	for _, elem := range strings.Split(strings.Trim(name, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		if !n.Dir {
+			if !create {
+				return nil
+			}
+			n.replace(layer, true)
+		}
+
+		child, ok := n.Children[elem]
+		if !ok {
+			if !create {
+				return nil
+			}
+			child = &layerNode{Layer: layer, Dir: true, Children: map[string]*layerNode{}}
+			n.Children[elem] = child
+		}
+		n = child
+	}
+	return n
+}
+
+// replace makes n an entry of layer, a directory replacing a file keeps
+// nothing of it and a file replacing a directory hides its contents
+func (n *layerNode) replace(layer int, dir bool) {
+	if !dir || !n.Dir {
+		n.pruneChildren(layer)
+	}
+	n.Layer, n.Dir, n.File = layer, dir, nil
+}
+
+// prune removes the paths of the layers below layer beneath n, and returns
+// whether n itself is removed
+func (n *layerNode) prune(layer int) bool {
+	n.pruneChildren(layer)
+	return n.Layer < layer && len(n.Children) == 0
+}
+
+func (n *layerNode) pruneChildren(layer int) {
+	for name, child := range n.Children {
+		if child.prune(layer) {
+			delete(n.Children, name)
+		}
+	}
+}
+
+// files calls fn with every regular file beneath n
+func (n *layerNode) files(name string, fn func(name string, lf *layerFile)) {
+	if n.File != nil {
+		fn(name, n.File)
+	}
+	for elem, child := range n.Children {
+		child.files(path.Join(name, elem), fn)
+	}
+}
+
+// Returns the names of the regular files of the final filesystem of an image,
+// keyed by the member holding their contents
+func flatten(c *imageConfig, layers []*io.SectionReader) (map[layerFile][]string, error) {
+	root := &layerNode{Layer: -1, Dir: true, Children: map[string]*layerNode{}}
+
+	for i, layer := range layers {
+		copies := map[string]int{}
+
+		err := walkLayer(c.Layers[i], layer, func(hdr *tar.Header, r io.Reader) error {
+			name := path.Join("/", hdr.Name)
+			dir, base := path.Split(name)
+
+			switch {
+			case base == WHITEOUT_OPAQUE:
+				if n := root.lookup(dir, i, false); n != nil && n.Dir {
+					n.pruneChildren(i)
+				}
+				return nil
+			case strings.HasPrefix(base, WHITEOUT_PREFIX):
+				if n := root.lookup(dir, i, false); n != nil && n.Dir {
+					target := base[len(WHITEOUT_PREFIX):]
+					if child, ok := n.Children[target]; ok && child.prune(i) {
+						delete(n.Children, target)
+					}
+				}
+				return nil
+			}
+
+			n := root.lookup(name, i, true)
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				n.replace(i, true)
+			case tar.TypeReg:
+				n.replace(i, false)
+				n.File = &layerFile{Layer: i, Name: name, Copy: copies[name]}
+				copies[name]++
+			case tar.TypeLink:
+				// hard links share the contents of the member of their target
+				var lf *layerFile
+				if target := root.lookup(path.Join("/", hdr.Linkname), i, false); target != nil {
+					lf = target.File
+				}
+				n.replace(i, false)
+				n.File = lf
+			default:
+				n.replace(i, false)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	files := map[layerFile][]string{}
+	root.files("/", func(name string, lf *layerFile) {
+		files[*lf] = append(files[*lf], name)
+	})
+	for _, names := range files {
+		sort.Strings(names)
+	}
+	return files, nil
+}
+
+// Walk visits the regular files of the final filesystem of every image. Names
+// are prefixed with the image name when the tarball holds several images.
+func (img *Image) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	for _, c := range img.Images {
+		if err := img.walkImage(c, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (img *Image) walkImage(c *imageConfig, fn func(name string, size int64, r io.Reader) error) error {
+	layers := []*io.SectionReader{}
+	for _, name := range c.Layers {
+		layer, close, err := img.openLayer(name)
+		if err != nil {
+			return err
+		}
+		defer close()
+		layers = append(layers, layer)
+	}
+
+	files, err := flatten(c, layers)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Flattened image", "image", c.Name, "layers", len(c.Layers), "files", len(files))
+
+	for i, layer := range layers {
+		copies := map[string]int{}
+
+		err := walkLayer(c.Layers[i], layer, func(hdr *tar.Header, r io.Reader) error {
+			if hdr.Typeflag != tar.TypeReg {
+				return nil
+			}
+			name := path.Join("/", hdr.Name)
+			lf := layerFile{Layer: i, Name: name, Copy: copies[name]}
+			copies[name]++
+
+			names := files[lf]
+			if len(names) > 1 {
+				// the contents are shared by the hard links to the file
+				data, err := readMember(name, hdr.Size, r)
+				if err != nil {
+					return err
+				}
+				for _, n := range names {
+					if err := fn(img.memberName(c, n), hdr.Size, bytes.NewReader(data)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+			if len(names) == 1 {
+				return fn(img.memberName(c, names[0]), hdr.Size, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// memberName prefixes name with the name of the image when the tarball holds
+// several images
+func (img *Image) memberName(c *imageConfig, name string) string {
+	if len(img.Images) > 1 {
+		return c.Name + ":" + name
+	}
+	return name
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// layerEntry is a member of a test layer: a regular file, a directory when the
+// name ends with a slash, a hard link or a symbolic link
+type layerEntry struct {
+	name     string
+	link     string
+	typeflag byte
+}
+
+func testLayer(t *testing.T, entries ...layerEntry) *io.SectionReader {
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typeflag, Mode: 0644}
+		switch {
+		case e.typeflag != 0:
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag = tar.TypeDir
+		default:
+			hdr.Typeflag, hdr.Size = tar.TypeReg, int64(len(e.name))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(e.name))
+		}
+	}
+	tw.Close()
+	return io.NewSectionReader(bytes.NewReader(b.Bytes()), 0, int64(b.Len()))
+}
+
+func TestFlatten(t *testing.T) {
+	layers := []*io.SectionReader{
+		testLayer(t,
+			layerEntry{name: "bin/"},
+			layerEntry{name: "bin/sh"},
+			layerEntry{name: "etc/"},
+			layerEntry{name: "etc/passwd"},
+			layerEntry{name: "etc/ssl/"},
+			layerEntry{name: "etc/ssl/cert.pem"},
+			layerEntry{name: "opt/"},
+			layerEntry{name: "opt/tool/"},
+			layerEntry{name: "opt/tool/a"},
+			layerEntry{name: "var/"},
+			layerEntry{name: "var/log"},
+			layerEntry{name: "lib"},
+		),
+		testLayer(t,
+			// hidden by an opaque whiteout, the files of this layer are kept
+			layerEntry{name: "etc/ssl/"},
+			layerEntry{name: "etc/ssl/.wh..wh..opq"},
+			layerEntry{name: "etc/ssl/ca.pem"},
+			layerEntry{name: "etc/.wh.passwd"},
+			// a file replacing a directory, and a directory replacing a file
+			layerEntry{name: "opt/tool"},
+			layerEntry{name: "var/log/"},
+			layerEntry{name: "var/log/messages"},
+			layerEntry{name: "lib/", typeflag: tar.TypeSymlink, link: "usr/lib"},
+			// a hard link to a file of a lower layer, and to one of the layer
+			layerEntry{name: "bin/bash", typeflag: tar.TypeLink, link: "bin/sh"},
+			layerEntry{name: "app/"},
+			layerEntry{name: "app/server"},
+			layerEntry{name: "app/server2", typeflag: tar.TypeLink, link: "app/server"},
+			// stored twice, the link keeps the first copy
+			layerEntry{name: "app/config"},
+			layerEntry{name: "app/config.orig", typeflag: tar.TypeLink, link: "app/config"},
+			layerEntry{name: "app/config"},
+		),
+		testLayer(t,
+			// the target of a hard link is removed, the link remains
+			layerEntry{name: "bin/.wh.sh"},
+			layerEntry{name: ".wh.opt"},
+		),
+	}
+
+	files, err := flatten(&imageConfig{Layers: []string{"l0", "l1", "l2"}}, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[layerFile][]string{
+		{Layer: 0, Name: "/bin/sh"}:              {"/bin/bash"},
+		{Layer: 1, Name: "/etc/ssl/ca.pem"}:      {"/etc/ssl/ca.pem"},
+		{Layer: 1, Name: "/var/log/messages"}:    {"/var/log/messages"},
+		{Layer: 1, Name: "/app/server"}:          {"/app/server", "/app/server2"},
+		{Layer: 1, Name: "/app/config"}:          {"/app/config.orig"},
+		{Layer: 1, Name: "/app/config", Copy: 1}: {"/app/config"},
+	}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("flattened to %v, want %v", files, want)
+	}
+}
+
+func TestImageWalk(t *testing.T) {
+	// the upper layer is compressed
+	upper := testLayer(t,
+		layerEntry{name: "app/server"},
+		layerEntry{name: "app/server2", typeflag: tar.TypeLink, link: "app/server"},
+		layerEntry{name: "bin/.wh.sh"},
+	)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	io.Copy(zw, upper)
+	zw.Close()
+
+	img := &Image{
+		Images: []*imageConfig{{Name: "test", Layers: []string{"lower", "upper"}}},
+		blobs: map[string]*io.SectionReader{
+			"lower": testLayer(t, layerEntry{name: "bin/sh"}, layerEntry{name: "bin/ls"}),
+			"upper": io.NewSectionReader(bytes.NewReader(gz.Bytes()), 0, int64(gz.Len())),
+		},
+	}
+
+	got := map[string]string{}
+	err := img.Walk(func(name string, size int64, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		got[name] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the contents of test files are their names
+	want := map[string]string{"/bin/ls": "bin/ls", "/app/server": "app/server", "/app/server2": "app/server"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+}
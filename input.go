@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	// decompress %s: %w
+	errDecompress = "decompress %s: %w"
+	// decompressed size exceeds %d (bytes)
+	errDecompressedSize = "decompressed size exceeds %d (bytes)"
+)
+
+const (
+	// largest decompressed input accepted, guards against decompression bombs
+	MAX_INPUT_SIZE int64 = 8e9 // ~8GB
+)
+
+// Compression describes a compressed input format recognized by its magic
+type Compression struct {
+	Name  string
+	Ext   string
+	Magic []byte
+	Open  func(r io.Reader) (io.Reader, error)
+}
+
+var compressions = []*Compression{
+	{
+		Name:  "gzip",
+		Ext:   ".gz",
+		Magic: []byte("\x1f\x8b"),
+		Open:  func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+	},
+	{
+		Name:  "xz",
+		Ext:   ".xz",
+		Magic: []byte("\xfd7zXZ\x00"),
+		Open:  func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) },
+	},
+	{
+		Name:  "zstd",
+		Ext:   ".zst",
+		Magic: []byte("\x28\xb5\x2f\xfd"),
+		Open: func(r io.Reader) (io.Reader, error) {
+			d, err := zstd.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+			return d.IOReadCloser(), nil
+		},
+	},
+	{
+		Name:  "bzip2",
+		Ext:   ".bz2",
+		Magic: []byte("BZh"),
+		Open:  func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+	},
+}
+
+// detectCompression returns the compression matching the leading bytes of an
+// input, or nil if it is not compressed
+func detectCompression(head []byte) *Compression {
+	for _, c := range compressions {
+		if bytes.HasPrefix(head, c.Magic) {
+			return c
+		}
+	}
+	return nil
+}
+
+// openInput opens the target `name`, "-" reads the target from stdin. Inputs
+// read from a pipe or stored compressed are spooled to a temporary file, which
+// provides the random access required by the executable parsers and allows the
+// contents to be mapped. The compression is detected from the contents rather
+// than the extension so piped and renamed inputs are handled the same way. Raw
+// memory images may start with any bytes, they are never decompressed.
+func openInput(name string, raw bool) (*os.File, error) {
+	var f *os.File
+	if name == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return nil, err
+		}
+	}
+
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(8)
+
+	var c *Compression
+	if !raw {
+		c = detectCompression(head)
+	}
+	if c == nil && isRegular(f) {
+		if f == os.Stdin {
+			// a redirected file, only the buffered bytes were consumed
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		return f, nil
+	}
+
+	if c != nil {
+		slog.Debug("Decompressing input", "format", c.Name)
+	}
+
+	if f != os.Stdin {
+		defer f.Close()
+	}
+
+	r := io.NopCloser(br)
+	if !raw {
+		var err error
+		if r, _, err = decompressReader(br); err != nil {
+			return nil, fmt.Errorf(errDecompress, name, err)
+		}
+	}
+	defer r.Close()
+
+	in, err := spool(r)
+	if err != nil {
+		return nil, fmt.Errorf(errDecompress, name, err)
+	}
+
+	return in, nil
+}
+
+// decompressReader wraps r with the decompressor matching its leading bytes.
+// The returned compression is nil when r is not compressed, in which case r is
+// read as is.
+func decompressReader(r io.Reader) (io.ReadCloser, *Compression, error) {
+	br := bufio.NewReader(r)
+	head, _ := br.Peek(8)
+
+	c := detectCompression(head)
+	if c == nil {
+		return io.NopCloser(br), nil, nil
+	}
+
+	dr, err := c.Open(br)
+	if err != nil {
+		return nil, c, err
+	}
+	if rc, ok := dr.(io.ReadCloser); ok {
+		return rc, c, nil
+	}
+	return io.NopCloser(dr), c, nil
+}
+
+// spool copies r to an unlinked temporary file
+func spool(r io.Reader) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "gorip-*")
+	if err != nil {
+		return nil, err
+	}
+	// the file remains accessible through the descriptor
+	os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, io.LimitReader(r, MAX_INPUT_SIZE+1))
+	if err == nil && n > MAX_INPUT_SIZE {
+		err = fmt.Errorf(errDecompressedSize, MAX_INPUT_SIZE)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+
+	slog.Debug("Spooled input", "size", n)
+	return tmp, nil
+}
+
+// isRegular reports whether f is a regular file, and so supports random access
+func isRegular(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Returns the name of the target without the compression extension, used to
+// name the generated reports
+func targetName(name string) string {
+	if name == "-" {
+		return "stdin"
+	}
+	for _, c := range compressions {
+		if strings.HasSuffix(name, c.Ext) {
+			return strings.TrimSuffix(name, c.Ext)
+		}
+	}
+	return name
+}
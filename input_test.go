@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenInput(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("decompressed"))
+	zw.Close()
+
+	// memory starting with the gzip magic, not followed by a gzip header
+	dump := append([]byte{0x1f, 0x8b}, bytes.Repeat([]byte{0xff}, 64)...)
+
+	tests := []struct {
+		name string
+		data []byte
+		raw  bool
+		want []byte // nil when opening fails
+	}{
+		{"gzip", gz.Bytes(), false, []byte("decompressed")},
+		{"gzip raw", gz.Bytes(), true, gz.Bytes()},
+		{"dump", dump, false, nil},
+		{"dump raw", dump, true, dump},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(name, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := openInput(name, tt.raw)
+		if tt.want == nil {
+			if err == nil {
+				f.Close()
+				t.Errorf("%s: opened", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// the parsers read through ReadAt
+		data, _ := io.ReadAll(io.NewSectionReader(f, 0, 1<<20))
+		f.Close()
+		if !bytes.Equal(data, tt.want) {
+			t.Errorf("%s: read %q, want %q", tt.name, data, tt.want)
+		}
+	}
+}
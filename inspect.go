@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+var (
+	// open %s: file does not exist
+	errPathNonexistent = "open %s: file does not exist"
+	// open %s: invalid argument
+	errPathInvalid = "open %s: invalid argument"
+	// readdir %s: not a directory
+	errNotDirectory = "readdir %s: not a directory"
+	// read %s: is a directory
+	errIsDirectory = "read %s: is a directory"
+)
+
+// split splits the name of an entry into its directory and element, mirroring
+// the unexported helper in embed.
+// reference: /src/embed/embed.go#L200-L216
+func split(name string) (dir, elem string, isDir bool) {
+	if name[len(name)-1] == '/' {
+		isDir = true
+		name = name[:len(name)-1]
+	}
+	i := len(name) - 1
+	for i >= 0 && name[i] != '/' {
+		i--
+	}
+	if i < 0 {
+		return ".", name, isDir
+	}
+	return name[:i], name[i+1:], isDir
+}
+
+// trimSlash removes the trailing slash that embed stores on directory names
+func trimSlash(name string) string {
+	if len(name) > 0 && name[len(name)-1] == '/' {
+		return name[:len(name)-1]
+	}
+	return name
+}
+
+// rootEntry synthesizes the "." directory, which embed does not store
+func rootEntry(sd *SectionData) *FSCEntry {
+	return &FSCEntry{Name: "./", IsDir: true, sd: sd}
+}
+
+// Lookup resolves a name the same way embed.FS.Open does and returns the
+// matching entry along with its index. The index of the synthesized root
+// directory is EntryCount.
+func (c *FSCandidate) Lookup(name string) (uint64, *FSCEntry, error) {
+	if !fs.ValidPath(name) {
+		return 0, nil, fmt.Errorf(errPathInvalid, name)
+	}
+	if name == "." {
+		return c.EntryCount, rootEntry(c.sd), nil
+	}
+
+	for i := uint64(0); i < c.EntryCount; i++ {
+		e := c.Entry(i)
+		if trimSlash(e.Name) == name {
+			return i, e, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf(errPathNonexistent, name)
+}
+
+// ReadDir returns the entries of the directory `name` in embed order
+func (c *FSCandidate) ReadDir(name string) ([]*FSCEntry, error) {
+	_, dir, err := c.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir {
+		return nil, fmt.Errorf(errNotDirectory, name)
+	}
+
+	entries := []*FSCEntry{}
+	for _, e := range c.Entries() {
+		if d, _, _ := split(e.Name); d == name {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// Returns the absolute file offset of an entry's data
+func (f *FSCEntry) FileOffset() uint64 {
+	return TL_FileOffset(f.sd, f.VirtualAddr())
+}
+
+// Returns the virtual address of an entry's data
+func (f *FSCEntry) VirtualAddr() uint64 {
+	return f.sd.VirtualAddr + f.sd.BaseAddr + f.Data.Addr
+}
+
+// Returns the last element of an entry's name
+func (f *FSCEntry) BaseName() string {
+	_, elem, isDir := split(f.Name)
+	if isDir {
+		return elem + "/"
+	}
+	return elem
+}
+
+// resolveCandidate returns the first candidate in which name exists. When
+// index is not negative only that candidate is considered.
+func resolveCandidate(candidates []*FSCandidate, index int, name string) (int, *FSCandidate, error) {
+	if index >= len(candidates) {
+		return 0, nil, fmt.Errorf("candidate %d does not exist (%d found)", index, len(candidates))
+	}
+	if !fs.ValidPath(name) {
+		return 0, nil, fmt.Errorf(errPathInvalid, name)
+	}
+	if index >= 0 {
+		_, _, err := candidates[index].Lookup(name)
+		return index, candidates[index], err
+	}
+
+	for i, c := range candidates {
+		if _, _, err := c.Lookup(name); err == nil {
+			return i, c, nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf(errPathNonexistent, name)
+}
+
+// List the contents of a directory, or a single file, inside a candidate
+func listEntries(writer io.Writer, c *FSCandidate, name string, long bool) error {
+	_, e, err := c.Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	entries := []*FSCEntry{e}
+	if e.IsDir {
+		if entries, err = c.ReadDir(name); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range entries {
+		if !long {
+			fmt.Fprintln(writer, e.BaseName())
+			continue
+		}
+
+		if e.IsDir {
+			fmt.Fprintf(writer, "d %9d %-32s %-11s %s\n", 0, strings.Repeat("-", 32), "-", e.BaseName())
+		} else {
+			fmt.Fprintf(writer, "- %9d %-32x %#-11x %s\n", e.Data.Size, e.Hash, e.FileOffset(), e.BaseName())
+		}
+	}
+
+	return nil
+}
+
+// Write the contents of a single embedded file
+func catEntry(writer io.Writer, c *FSCandidate, name string) error {
+	_, e, err := c.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if e.IsDir {
+		return fmt.Errorf(errIsDirectory, name)
+	}
+	if e.Unreadable() {
+		return fmt.Errorf("read %s: %s", name, e.Damage)
+	}
+
+	data, err := e.Read()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(data)
+	return err
+}
+
+// Output all known metadata of a single embedded file or directory
+func statEntry(writer io.Writer, c *FSCandidate, ci int, name string) error {
+	i, e, err := c.Lookup(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(writer, "  Name: %s\n", name)
+	fmt.Fprintf(writer, "  Candidate: %d VA: %#x FO: %#x\n", ci, c.Addr, TL_FileOffset(c.sd, c.Addr))
+
+	if i == c.EntryCount {
+		fmt.Fprintf(writer, "  Type: directory (synthesized root)\n")
+		return nil
+	}
+
+	offset := TL_FileOffset(c.sd, c.Addr) + c.EntrySize()*i
+	fmt.Fprintf(writer, "  Entry: %d of %d File offset: %#x\n", i, c.EntryCount, offset)
+
+	if e.IsDir {
+		fmt.Fprintf(writer, "  Type: directory\n")
+		return nil
+	}
+
+	fmt.Fprintf(writer, "  Type: file\n")
+	fmt.Fprintf(writer, "  Size: %d (%#[1]x)\n", e.Data.Size)
+	fmt.Fprintf(writer, "  Notsha256: %x\n", e.Hash)
+	fmt.Fprintf(writer, "  Data VA: %#x File offset: %#x\n", e.VirtualAddr(), e.FileOffset())
+	if e.Damage != "" {
+		fmt.Fprintf(writer, "  Damage: %s\n", e.Damage)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// unsupported log format \"%s\"
+	errLogFormat = "unsupported log format \"%s\""
+)
+
+// Diagnostics are written to stderr through log/slog so stdout only carries
+// the data requested by a command. The level is derived from the global
+// options: --verbose enables debug messages, --quiet only keeps warnings and
+// errors.
+func setupLogger(o *Options) error {
+	level := slog.LevelInfo
+	if o.Verbose {
+		level = slog.LevelDebug
+	} else if o.Quiet {
+		level = slog.LevelWarn
+	}
+
+	h, err := newLogHandler(&logWriter{w: os.Stderr}, o.LogFormat, level)
+	if err != nil {
+		return err
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// Returns a handler writing records in the given format, "text" or "json"
+func newLogHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	switch format {
+	case "text":
+		return &textHandler{w: w, level: level, mu: &sync.Mutex{}}, nil
+	case "json":
+		return slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level}), nil
+	}
+	return nil, fmt.Errorf(errLogFormat, format)
+}
+
+// logWriter clears the progress line, when one is drawn, before writing a
+// record to w. The line is drawn again by the next progress update.
+type logWriter struct {
+	w io.Writer
+}
+
+func (l *logWriter) Write(p []byte) (int, error) {
+	if progressActive.CompareAndSwap(true, false) {
+		io.WriteString(l.w, "\r\033[K")
+	}
+	return l.w.Write(p)
+}
+
+// hexAttr formats an address or offset the same way the reports do
+func hexAttr(key string, v uint64) slog.Attr {
+	return slog.String(key, fmt.Sprintf("%#x", v))
+}
+
+// textHandler renders records as a single human readable line, prefixed with
+// the marker of its level followed by the attributes in `key=value` form:
+//
+//	[~] debug  [+] info  [!] warning and error
+type textHandler struct {
+	w     io.Writer
+	level slog.Level
+	attrs []slog.Attr
+	group string
+
+	mu *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	switch {
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("[!] ")
+	case r.Level >= slog.LevelInfo:
+		b.WriteString("[+] ")
+	default:
+		b.WriteString("[~] ")
+	}
+	b.WriteString(r.Message)
+
+	for _, a := range h.attrs {
+		writeTextAttr(&b, "", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		writeTextAttr(&b, h.group, a)
+		return true
+	})
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	c.attrs = append(c.attrs, h.attrs...)
+	for _, a := range attrs {
+		if h.group != "" {
+			a.Key = h.group + a.Key
+		}
+		c.attrs = append(c.attrs, a)
+	}
+	return &c
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.group = h.group + name + "."
+	return &c
+}
+
+func writeTextAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			writeTextAttr(b, group, ga)
+		}
+		return
+	}
+
+	v := a.Value.String()
+	if v == "" || strings.ContainsAny(v, " \t\"=") {
+		v = fmt.Sprintf("%q", v)
+	}
+	fmt.Fprintf(b, " %s%s=%s", group, a.Key, v)
+}
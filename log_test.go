@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+func TestLogClearsProgress(t *testing.T) {
+	var b bytes.Buffer
+	h, err := newLogHandler(&logWriter{w: &b}, "text", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := slog.New(h)
+
+	progressActive.Store(true)
+	defer progressActive.Store(false)
+	log.Info("first")
+	log.Info("second")
+
+	if want := "\r\033[K[+] first\n[+] second\n"; b.String() != want {
+		t.Fatalf("got %q, want %q", b.String(), want)
+	}
+}
@@ -1,151 +1,52 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 // NOTE:
 // Hash algo: cmd/internal/notsha256
 
 const (
-	MAX_FILE_SIZE      int64  = 2e9              // ~2GB
-	DEFAULT_CHUNK_SIZE uint64 = 1024 * 1024 * 16 // 16MB
+	MAX_FILE_SIZE           int64  = 2e9              // ~2GB
+	DEFAULT_CHUNK_SIZE      uint64 = 1024 * 1024 * 16 // 16MB
+	DEFAULT_MAX_NAME_LENGTH uint64 = 255
 )
 
-var (
-	flagTargetBin string
-
-	flagChunkSize        uint64 = DEFAULT_CHUNK_SIZE
-	flagExtractCandidate bool   = false
-	flagGenerateManifest bool   = false
-	flagGenerateFSTree   bool   = false
-	flagVerbose          bool   = false
-)
-
-func init() {
-	const (
-		usage string = `Usage: ./gorip [options] <binary>
-
-Options:
-  -c, --chunk-size <size>
-      Set chunk size in bytes (default: 16777216 (16 MB))
-
-  -e, --extract
-      Extract candidates from the binary (default: false)
-
-  -m, --manifest
-      Generate a candidate manifest for the binary (default: false)
-
-  -t, --tree
-	  Generate a file tree for the binary (default: false)
-
-  -v, --verbose
-	  Increase verbosity
-
-Examples:
-  ./gorip -c 1048576 -e ./path/to/binary
-  ./gorip --manifest --tree ./path/to/binary`
-	)
-
-	fs := flag.NewFlagSet("", flag.ExitOnError)
-	fs.Usage = func() {
-		fmt.Fprintln(os.Stdout, usage)
-	}
-
-	fs.BoolVar(&flagExtractCandidate, "extract", false, "")
-	fs.BoolVar(&flagExtractCandidate, "e", false, "")
-
-	fs.BoolVar(&flagGenerateManifest, "manifest", false, "")
-	fs.BoolVar(&flagGenerateManifest, "m", false, "")
-
-	fs.BoolVar(&flagGenerateFSTree, "tree", false, "")
-	fs.BoolVar(&flagGenerateFSTree, "t", false, "")
-
-	fs.BoolVar(&flagVerbose, "verbose", false, "")
-	fs.BoolVar(&flagVerbose, "v", false, "")
-
-	fs.Uint64Var(&flagChunkSize, "chunk-size", DEFAULT_CHUNK_SIZE, "")
-	fs.Uint64Var(&flagChunkSize, "c", DEFAULT_CHUNK_SIZE, "")
-
-	fs.Parse(os.Args[1:])
-	args := fs.Args()
-
-	if len(args) == 0 {
-		fs.Usage()
-		os.Exit(1)
-	}
-	flagTargetBin = args[0]
-	flagChunkSize += flagChunkSize % 2 // chunk size should be a multiple of 2
-
-	// fmt.Printf("CS: %d EC: %v GM: %v FST: %v V: %v\n", flagChunkSize, flagExtractCandidate, flagGenerateManifest, flagGenerateFSTree, flagVerbose)
-}
-
 func main() {
-	f, err := os.Open(flagTargetBin)
-	if err != nil {
-		panic(err)
+	cmd, opts, err := parseArgs(os.Args[1:], os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
 	}
-	defer f.Close()
-
-	x, err := DetectExeFormat(f)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "gorip:", err)
+		fmt.Fprintln(os.Stderr, "Run \"./gorip help [command]\" for usage.")
+		os.Exit(2)
 	}
-
-	fmt.Println("[+] Detected format:", x.FormatName())
-	sd, err := x.Rodata()
-	if err != nil {
-		panic(err)
+	if err := setupLogger(opts); err != nil {
+		fmt.Fprintln(os.Stderr, "gorip:", err)
+		os.Exit(2)
 	}
 
-	if flagVerbose {
-		PrintSectionInfo(sd)
-	}
-
-	start := time.Now()
-	candidates := findCandidates(sd)
-	elapsed := time.Since(start)
-
-	// there are probably better ways of measuring this
-	ops := sd.FileSize / uint64(elapsed.Milliseconds())
-	fmt.Printf("[+] Candidate(s) found: %d. Took %v (~%d B/ms)\n", len(candidates), elapsed, ops)
-
-	if flagExtractCandidate {
-		extractCandidates(candidates)
-	}
-	if flagGenerateManifest {
-		generateManifest(sd, candidates, f.Name())
-	}
-	if flagGenerateFSTree {
-		generateFileTree(candidates, f.Name())
-	}
-}
-
-func generateManifest(sd *SectionData, candidates []*FSCandidate, name string) {
-	var writer io.Writer
-
-	if len(name) > 0 {
-		fname := fmt.Sprintf("%s.manifest", name)
-		fname = filepath.Base(fname)
-
-		m, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0755)
-		if err != nil {
-			panic(err)
+	if err := cmd.Run(opts); err != nil {
+		if errors.Is(err, errNoMatches) {
+			os.Exit(1)
 		}
-
-		defer m.Close()
-		writer = m
-	} else {
-		writer = os.Stdout
+		slog.Error(err.Error(), "command", cmd.Name)
+		os.Exit(1)
 	}
+}
 
+func generateManifest(writer io.Writer, candidates []*FSCandidate) {
 	for _, candidate := range candidates {
-		fmt.Fprintf(writer, "Candidate VA: %#x FO: %#x\n", candidate.Addr, TL_FileOffset(sd, candidate.Addr))
+		fmt.Fprintf(writer, "Candidate VA: %#x FO: %#x Confidence: %s\n", candidate.Addr, TL_FileOffset(candidate.sd, candidate.Addr), candidate.Score)
+		writeScoreDetails(writer, candidate.Score)
 		fmt.Fprintf(writer, "%3s %9s %-32s %-11s %s\n", "", "Size", "Notsha256", "File offset", "Name")
 
 		size := 0
@@ -155,7 +56,11 @@ func generateManifest(sd *SectionData, candidates []*FSCandidate, name string) {
 			e := candidate.Entry(i)
 
 			offset := TL_FileOffset(candidate.sd, candidate.Addr) + candidate.EntrySize()*i
-			fmt.Fprintf(writer, "%-3d %9d %-32x %#-11x %s\n", i, e.Data.Size, e.Hash, offset, e.Name)
+			if e.Damage != "" {
+				fmt.Fprintf(writer, "%-3d %9d %-32x %#-11x %s [!] %s\n", i, e.Data.Size, e.Hash, offset, e.Name, e.Damage)
+			} else {
+				fmt.Fprintf(writer, "%-3d %9d %-32x %#-11x %s\n", i, e.Data.Size, e.Hash, offset, e.Name)
+			}
 
 			size += int(e.Data.Size)
 			if e.IsDir {
@@ -163,59 +68,132 @@ func generateManifest(sd *SectionData, candidates []*FSCandidate, name string) {
 			}
 		}
 		fmt.Fprintf(writer, "[+] Total Size: %d (bytes) %d files %d folders\n", size, int(candidate.EntryCount)-d, d)
+
+		entries := candidate.Entries()
+		writeDuplicateGroups(writer, "hash", DuplicatesByHash(entries))
+		writeDuplicateGroups(writer, "data address", DuplicatesByAddr(entries))
 		fmt.Fprintln(writer)
 	}
 }
 
-func generateFileTree(candidates []*FSCandidate, name string) {
-	var writer io.Writer
+func generateFileTree(writer io.Writer, candidates []*FSCandidate, opt *TreeOptions) error {
+	var trees []*FileTree
+	var errs []error
 
-	if len(name) > 0 {
-		fname := fmt.Sprintf("%s.tree", name)
-		fname = filepath.Base(fname)
-
-		m, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0755)
-		if err != nil {
-			panic(err)
+	if opt.Merge {
+		tree := NewFileTree()
+		for _, candidate := range candidates {
+			for _, e := range candidate.Entries() {
+				if err := tree.Insert(e); err != nil {
+					errs = append(errs, err)
+				}
+			}
 		}
-
-		defer m.Close()
-		writer = m
+		trees = append(trees, tree)
 	} else {
-		writer = os.Stdout
+		for _, candidate := range candidates {
+			tree, terrs := NewCandidateTree(candidate)
+			trees = append(trees, tree)
+			errs = append(errs, terrs...)
+		}
 	}
 
-	tree := NewFileTree()
-	for _, candidate := range candidates {
-		for _, e := range candidate.Entries() {
-			tree.Insert(e)
+	conflicts := FindPathConflicts(candidates)
+	if opt.JSON {
+		return RenderTreeReportJSON(writer, candidates, trees, conflicts, opt)
+	}
+
+	for i, tree := range trees {
+		if !opt.Merge {
+			c := candidates[i]
+			fmt.Fprintf(writer, "Candidate %d VA: %#x FO: %#x\n", i, c.Addr, TL_FileOffset(c.sd, c.Addr))
+		}
+		if err := RenderTree(writer, tree.Root, opt); err != nil {
+			return err
+		}
+		fmt.Fprintln(writer)
+	}
+
+	writePathConflicts(writer, conflicts)
+	if !opt.Merge {
+		// conflicts between candidates are already reported above
+		for _, err := range errs {
+			fmt.Fprintf(writer, "[!] %v\n", err)
 		}
 	}
 
-	PrintTreeSorted(tree.Root, "", writer)
+	return nil
 }
 
-func extractCandidates(candidates []*FSCandidate) {
+// Returns the number of bytes selected for extraction by the filter
+func extractSize(candidates []*FSCandidate, filter *EntryFilter) uint64 {
+	size := uint64(0)
 	for _, candidate := range candidates {
 		for _, entry := range candidate.Entries() {
-			if entry.IsDir {
-				os.Mkdir(entry.Name, 0755)
-			} else {
-				// TODO: Should probably change this to an iterator so significantly large
-				// files are not loaded completely into memory.
-				data, err := entry.Read()
-				if err != nil {
-					panic(err)
-				}
+			if !entry.IsDir && !entry.Unreadable() && filter.Match(entry) {
+				size += entry.Data.Size
+			}
+		}
+	}
+	return size
+}
 
-				f, err := os.OpenFile(entry.Name, os.O_RDWR|os.O_CREATE, 0755)
-				if err != nil {
-					panic(err)
-				}
+// Extract candidates to the invocation directory. Returns the number of bytes
+// written.
+func extractCandidates(candidates []*FSCandidate, filter *EntryFilter, progress *Progress) uint64 {
+	written := uint64(0)
 
-				f.Write(data)
-				f.Close()
+	for _, candidate := range candidates {
+		for _, entry := range candidate.Entries() {
+			if !filter.Match(entry) {
+				continue
+			}
+			if err := extractEntry(entry); err != nil {
+				panic(err)
+			}
+			if !entry.IsDir && !entry.Unreadable() {
+				written += entry.Data.Size
+				progress.Add(entry.Data.Size)
 			}
 		}
 	}
+
+	return written
+}
+
+// Write a single entry to the invocation directory. Damaged entries are
+// reported, and skipped if their contents can not be read.
+func extractEntry(entry *FSCEntry) error {
+	if !validEntryName(entry.Name) {
+		slog.Warn("Skipping entry outside of the output directory", "name", entry.Name)
+		return nil
+	}
+	if entry.Damage != "" {
+		slog.Warn("Damaged entry", "name", entry.Name, "damage", entry.Damage)
+		if entry.Unreadable() {
+			return nil
+		}
+	}
+
+	if entry.IsDir {
+		os.Mkdir(entry.Name, 0755)
+		return nil
+	}
+	// parent directories may have been filtered out
+	os.MkdirAll(filepath.Dir(entry.Name), 0755)
+
+	// TODO: Should probably change this to an iterator so significantly large
+	// files are not loaded completely into memory.
+	data, err := entry.Read()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(entry.Name, os.O_RDWR|os.O_CREATE, 0755)
+	if err != nil {
+		return err
+	}
+
+	f.Write(data)
+	return f.Close()
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"unicode/utf16"
+)
+
+var (
+	// malformed minidump: %s
+	errMinidump = "malformed minidump: %s"
+	// minidump has no memory of module %s
+	errMinidumpMemory = "minidump has no memory of module %s"
+)
+
+const (
+	MINIDUMP_SIGNATURE = "MDMP"
+
+	// reference: minidumpapiset.h MINIDUMP_STREAM_TYPE
+	MINIDUMP_MODULE_LIST_STREAM   = 4
+	MINIDUMP_MEMORY_LIST_STREAM   = 5
+	MINIDUMP_SYSTEM_INFO_STREAM   = 7
+	MINIDUMP_MEMORY64_LIST_STREAM = 9
+
+	MINIDUMP_HEADER_SIZE    = 32
+	MINIDUMP_DIRECTORY_SIZE = 12
+	MINIDUMP_MODULE_SIZE    = 108
+
+	// PROCESSOR_ARCHITECTURE_* values with 32-bit pointers
+	PROCESSOR_ARCHITECTURE_INTEL = 0
+	PROCESSOR_ARCHITECTURE_ARM   = 5
+
+	// magic of the build information written by the Go linker
+	GO_BUILDINFO_MAGIC = "\xff Go buildinf:"
+	// memory searched for the build information at once
+	MINIDUMP_SEARCH_CHUNK = 1024 * 1024
+)
+
+// MinidumpModule is an executable or library loaded in the dumped process
+type MinidumpModule struct {
+	Name string
+	Base uint64
+	Size uint64
+}
+
+// MinidumpRange is a range of the process memory stored in the dump
+type MinidumpRange struct {
+	Addr uint64
+	Size uint64
+	Rva  uint64 // file offset of the contents
+}
+
+// exeMinidump is a Windows minidump. The memory ranges of the Go module are
+// scanned in place of its sections.
+type exeMinidump struct {
+	r     io.ReaderAt
+	size  int64
+	ptrsz int
+
+	Modules []*MinidumpModule
+	Ranges  []*MinidumpRange
+	// module scanned for candidates
+	Module *MinidumpModule
+}
+
+// NewMinidump parses the stream directory of the minidump r
+// reference: https://learn.microsoft.com/en-us/windows/win32/api/minidumpapiset/
+func NewMinidump(r io.ReaderAt) (*exeMinidump, error) {
+	hdr := make([]byte, MINIDUMP_HEADER_SIZE)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, err
+	}
+	if string(hdr[:4]) != MINIDUMP_SIGNATURE {
+		return nil, fmt.Errorf(errUnrecognizedFormat)
+	}
+
+	count := binary.LittleEndian.Uint32(hdr[8:12])
+	rva := int64(binary.LittleEndian.Uint32(hdr[12:16]))
+
+	x := &exeMinidump{r: r, size: readerSize(r), ptrsz: 8}
+
+	dir := make([]byte, MINIDUMP_DIRECTORY_SIZE)
+	for i := int64(0); i < int64(count); i++ {
+		if _, err := r.ReadAt(dir, rva+i*MINIDUMP_DIRECTORY_SIZE); err != nil {
+			return nil, fmt.Errorf(errMinidump, "stream directory")
+		}
+
+		stream := binary.LittleEndian.Uint32(dir[0:4])
+		size := int64(binary.LittleEndian.Uint32(dir[4:8]))
+		offset := int64(binary.LittleEndian.Uint32(dir[8:12]))
+
+		var err error
+		switch stream {
+		case MINIDUMP_SYSTEM_INFO_STREAM:
+			err = x.readSystemInfo(offset)
+		case MINIDUMP_MODULE_LIST_STREAM:
+			err = x.readModuleList(offset)
+		case MINIDUMP_MEMORY64_LIST_STREAM:
+			err = x.readMemory64List(offset)
+		case MINIDUMP_MEMORY_LIST_STREAM:
+			err = x.readMemoryList(offset, size)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(x.Ranges, func(i, j int) bool { return x.Ranges[i].Addr < x.Ranges[j].Addr })
+	if len(x.Modules) == 0 {
+		return nil, fmt.Errorf(errMinidump, "no module list")
+	}
+	x.Module = x.goModule()
+
+	return x, nil
+}
+
+func (x *exeMinidump) read(offset, n int64) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := x.r.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (x *exeMinidump) readSystemInfo(offset int64) error {
+	b, err := x.read(offset, 2)
+	if err != nil {
+		return fmt.Errorf(errMinidump, "system info")
+	}
+
+	switch binary.LittleEndian.Uint16(b) {
+	case PROCESSOR_ARCHITECTURE_INTEL, PROCESSOR_ARCHITECTURE_ARM:
+		x.ptrsz = 4
+	}
+	return nil
+}
+
+func (x *exeMinidump) readModuleList(offset int64) error {
+	b, err := x.read(offset, 4)
+	if err != nil {
+		return fmt.Errorf(errMinidump, "module list")
+	}
+
+	count := int64(binary.LittleEndian.Uint32(b))
+	for i := int64(0); i < count; i++ {
+		m, err := x.read(offset+4+i*MINIDUMP_MODULE_SIZE, MINIDUMP_MODULE_SIZE)
+		if err != nil {
+			return fmt.Errorf(errMinidump, "module list")
+		}
+
+		x.Modules = append(x.Modules, &MinidumpModule{
+			Name: x.readString(int64(binary.LittleEndian.Uint32(m[20:24]))),
+			Base: binary.LittleEndian.Uint64(m[0:8]),
+			Size: uint64(binary.LittleEndian.Uint32(m[8:12])),
+		})
+	}
+	return nil
+}
+
+// readString reads a MINIDUMP_STRING, a length prefixed UTF-16 string
+func (x *exeMinidump) readString(offset int64) string {
+	b, err := x.read(offset, 4)
+	if err != nil {
+		return ""
+	}
+
+	n := int64(binary.LittleEndian.Uint32(b))
+	if n > 0xffff {
+		return ""
+	}
+	if b, err = x.read(offset+4, n); err != nil {
+		return ""
+	}
+
+	u := make([]uint16, n/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// Memory64List stores the contents of every range contiguously from BaseRva
+func (x *exeMinidump) readMemory64List(offset int64) error {
+	b, err := x.read(offset, 16)
+	if err != nil {
+		return fmt.Errorf(errMinidump, "memory64 list")
+	}
+
+	count := int64(binary.LittleEndian.Uint64(b[0:8]))
+	rva := binary.LittleEndian.Uint64(b[8:16])
+
+	if count < 0 || count > 1<<24 {
+		return fmt.Errorf(errMinidump, "memory64 list")
+	}
+	for i := int64(0); i < count; i++ {
+		d, err := x.read(offset+16+i*16, 16)
+		if err != nil {
+			return fmt.Errorf(errMinidump, "memory64 list")
+		}
+
+		r := &MinidumpRange{
+			Addr: binary.LittleEndian.Uint64(d[0:8]),
+			Size: binary.LittleEndian.Uint64(d[8:16]),
+			Rva:  rva,
+		}
+		x.addRange(r)
+		rva += r.Size
+	}
+	return nil
+}
+
+func (x *exeMinidump) readMemoryList(offset, size int64) error {
+	b, err := x.read(offset, 4)
+	if err != nil {
+		return fmt.Errorf(errMinidump, "memory list")
+	}
+
+	count := int64(binary.LittleEndian.Uint32(b))
+	if 4+count*16 > size {
+		return fmt.Errorf(errMinidump, "memory list")
+	}
+	for i := int64(0); i < count; i++ {
+		d, err := x.read(offset+4+i*16, 16)
+		if err != nil {
+			return fmt.Errorf(errMinidump, "memory list")
+		}
+
+		x.addRange(&MinidumpRange{
+			Addr: binary.LittleEndian.Uint64(d[0:8]),
+			Size: uint64(binary.LittleEndian.Uint32(d[8:12])),
+			Rva:  uint64(binary.LittleEndian.Uint32(d[12:16])),
+		})
+	}
+	return nil
+}
+
+// addRange records r unless its contents run past the end of the dump, as in
+// truncated dumps
+func (x *exeMinidump) addRange(r *MinidumpRange) {
+	if r.Rva > uint64(x.size) || r.Size > uint64(x.size)-r.Rva {
+		slog.Debug("Ignoring memory range past the end of the dump", hexAttr("addr", r.Addr), "size", r.Size)
+		return
+	}
+	x.Ranges = append(x.Ranges, r)
+}
+
+// moduleRanges returns the dumped memory ranges clipped to the image of m
+func (x *exeMinidump) moduleRanges(m *MinidumpModule) []*MinidumpRange {
+	ranges := []*MinidumpRange{}
+
+	for _, r := range x.Ranges {
+		start, end := max(r.Addr, m.Base), min(r.Addr+r.Size, m.Base+m.Size)
+		if start >= end {
+			continue
+		}
+		ranges = append(ranges, &MinidumpRange{Addr: start, Size: end - start, Rva: r.Rva + start - r.Addr})
+	}
+	return ranges
+}
+
+// goModule returns the module whose memory holds the Go build information,
+// or the first module, the main executable, if none does
+func (x *exeMinidump) goModule() *MinidumpModule {
+	for _, m := range x.Modules {
+		for _, r := range x.moduleRanges(m) {
+			if x.containsBuildInfo(r) {
+				slog.Debug("Found Go module", "name", m.Name, hexAttr("base", m.Base))
+				return m
+			}
+		}
+	}
+	return x.Modules[0]
+}
+
+// containsBuildInfo searches the contents of r for the build information magic
+// in chunks, overlapping so the magic is found across chunk boundaries
+func (x *exeMinidump) containsBuildInfo(r *MinidumpRange) bool {
+	overlap := int64(len(GO_BUILDINFO_MAGIC) - 1)
+	buf := make([]byte, MINIDUMP_SEARCH_CHUNK)
+
+	for offset := int64(0); offset < int64(r.Size); offset += MINIDUMP_SEARCH_CHUNK - overlap {
+		n, err := x.r.ReadAt(buf[:min(MINIDUMP_SEARCH_CHUNK, int64(r.Size)-offset)], int64(r.Rva)+offset)
+		if bytes.Contains(buf[:n], []byte(GO_BUILDINFO_MAGIC)) {
+			return true
+		}
+		if err != nil || offset+int64(n) >= int64(r.Size) {
+			break
+		}
+	}
+	return false
+}
+
+func (x *exeMinidump) FormatName() string { return "minidump" }
+
+func (x *exeMinidump) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, ".rdata")
+}
+
+func (x *exeMinidump) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+// Sections returns the memory of the Go module, ranges contiguous both in
+// memory and in the dump are merged
+func (x *exeMinidump) Sections() ([]*SectionData, error) {
+	sections := []*SectionData{}
+	var last *SectionData
+
+	for _, r := range x.moduleRanges(x.Module) {
+		if last != nil && r.Addr == last.VirtualAddr+last.VirtualSize && r.Rva == last.FileOffset+last.FileSize {
+			last.VirtualSize += r.Size
+			last.FileSize += r.Size
+			continue
+		}
+
+		last = &SectionData{
+			Name: fmt.Sprintf("%s %#x", x.Module.Name, r.Addr),
+
+			VirtualAddr: r.Addr,
+			VirtualSize: r.Size,
+			FileOffset:  r.Rva,
+			FileSize:    r.Size,
+
+			Order: binary.LittleEndian,
+			Ptrsz: x.ptrsz,
+		}
+		sections = append(sections, last)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf(errMinidumpMemory, x.Module.Name)
+	}
+
+	for _, s := range sections {
+		s.Data = io.NewSectionReader(x.r, int64(s.FileOffset), int64(s.FileSize))
+	}
+	return sections, nil
+}
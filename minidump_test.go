@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+// testMinidump returns a minidump of two modules, an executable and a Go
+// library holding the build information. Memory64List stores three ranges:
+// one straddling the start of the executable, one larger than the library and
+// one running past the end of the dump.
+func testMinidump() []byte {
+	const (
+		dirOff, sysOff, modOff, memOff = 0x20, 0x60, 0x80, 0x300
+		dataOff, size                  = 0x400, 0x700
+	)
+	le := binary.LittleEndian
+	img := make([]byte, size)
+
+	copy(img, MINIDUMP_SIGNATURE)
+	le.PutUint32(img[8:], 3)
+	le.PutUint32(img[12:], dirOff)
+
+	dir := img[dirOff:]
+	for i, s := range [][3]uint32{
+		{MINIDUMP_SYSTEM_INFO_STREAM, 56, sysOff},
+		{MINIDUMP_MODULE_LIST_STREAM, 4 + 2*MINIDUMP_MODULE_SIZE, modOff},
+		{MINIDUMP_MEMORY64_LIST_STREAM, 16 + 3*16, memOff},
+	} {
+		le.PutUint32(dir[i*MINIDUMP_DIRECTORY_SIZE:], s[0])
+		le.PutUint32(dir[i*MINIDUMP_DIRECTORY_SIZE+4:], s[1])
+		le.PutUint32(dir[i*MINIDUMP_DIRECTORY_SIZE+8:], s[2])
+	}
+
+	// PROCESSOR_ARCHITECTURE_AMD64
+	le.PutUint16(img[sysOff:], 9)
+
+	le.PutUint32(img[modOff:], 2)
+	for i, m := range []MinidumpModule{
+		{Name: "a.exe", Base: 0x10080, Size: 0x1000},
+		{Name: "go.dll", Base: 0x20000, Size: 0x80},
+	} {
+		mod := img[modOff+4+i*MINIDUMP_MODULE_SIZE:]
+		le.PutUint64(mod[0:], m.Base)
+		le.PutUint32(mod[8:], uint32(m.Size))
+
+		name := 0x200 + i*0x20
+		le.PutUint32(mod[20:], uint32(name))
+		u := utf16.Encode([]rune(m.Name))
+		le.PutUint32(img[name:], uint32(len(u)*2))
+		for j, c := range u {
+			le.PutUint16(img[name+4+j*2:], c)
+		}
+	}
+
+	le.PutUint64(img[memOff:], 3)
+	le.PutUint64(img[memOff+8:], dataOff)
+	for i, r := range [][2]uint64{{0x10000, 0x100}, {0x20000, 0x100}, {0x30000, 0x1000}} {
+		le.PutUint64(img[memOff+16+i*16:], r[0])
+		le.PutUint64(img[memOff+24+i*16:], r[1])
+	}
+
+	copy(img[0x510:], GO_BUILDINFO_MAGIC)
+	return img
+}
+
+func TestMinidump(t *testing.T) {
+	x, err := NewMinidump(bytes.NewReader(testMinidump()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if x.ptrsz != 8 || len(x.Modules) != 2 || x.Modules[0].Name != "a.exe" {
+		t.Fatalf("got pointer size %d, modules %+v", x.ptrsz, x.Modules)
+	}
+	// the range past the end of the dump is dropped
+	want := []*MinidumpRange{{Addr: 0x10000, Size: 0x100, Rva: 0x400}, {Addr: 0x20000, Size: 0x100, Rva: 0x500}}
+	if !reflect.DeepEqual(x.Ranges, want) {
+		t.Errorf("got ranges %+v, want %+v", x.Ranges, want)
+	}
+	if x.Module != x.Modules[1] {
+		t.Errorf("got module %s, want go.dll", x.Module.Name)
+	}
+
+	// ranges are clipped to the image of the module
+	for _, tt := range []struct {
+		m    *MinidumpModule
+		want []*MinidumpRange
+	}{
+		{x.Modules[0], []*MinidumpRange{{Addr: 0x10080, Size: 0x80, Rva: 0x480}}},
+		{x.Modules[1], []*MinidumpRange{{Addr: 0x20000, Size: 0x80, Rva: 0x500}}},
+		{&MinidumpModule{Name: "c.dll", Base: 0x40000, Size: 0x1000}, []*MinidumpRange{}},
+	} {
+		if got := x.moduleRanges(tt.m); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got ranges %+v, want %+v", tt.m.Name, got, tt.want)
+		}
+	}
+
+	sections, err := x.Sections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 1 || sections[0].VirtualAddr != 0x20000 || sections[0].FileOffset != 0x500 || sections[0].FileSize != 0x80 {
+		t.Errorf("got sections %+v", sections)
+	}
+}
+
+func TestMinidumpTruncated(t *testing.T) {
+	img := testMinidump()
+
+	// stream directory, module list and memory64 list cut short
+	for _, n := range []int{0x10, 0x40, 0x100, 0x310} {
+		if _, err := NewMinidump(bytes.NewReader(img[:n])); err == nil {
+			t.Errorf("truncated at %#x: no error", n)
+		}
+	}
+}
+
+func TestContainsBuildInfo(t *testing.T) {
+	img := make([]byte, 2*MINIDUMP_SEARCH_CHUNK)
+	x := &exeMinidump{r: bytes.NewReader(img), size: int64(len(img))}
+	r := &MinidumpRange{Rva: 0x10, Size: uint64(len(img) - 0x20)}
+
+	for _, tt := range []struct {
+		offset int
+		want   bool
+	}{
+		// across the boundary of the first chunk
+		{0x10 + MINIDUMP_SEARCH_CHUNK - 4, true},
+		// at the end of the range, then past it
+		{len(img) - 0x10 - len(GO_BUILDINFO_MAGIC), true},
+		{len(img) - 0x10 - len(GO_BUILDINFO_MAGIC) + 1, false},
+	} {
+		clear(img)
+		copy(img[tt.offset:], GO_BUILDINFO_MAGIC)
+		if got := x.containsBuildInfo(r); got != tt.want {
+			t.Errorf("magic at %#x: got %v, want %v", tt.offset, got, tt.want)
+		}
+	}
+}
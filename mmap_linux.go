@@ -0,0 +1,27 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the whole file read-only into memory. The returned function
+// releases the mapping.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Size() <= 0 || int64(int(info.Size())) != info.Size() {
+		return nil, nil, errMmapUnsupported
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, func() error { return syscall.Munmap(b) }, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+)
+
+// mmapFile is only implemented on Linux, callers fall back to reading the
+// section through its reader.
+func mmapFile(f *os.File) ([]byte, func() error, error) {
+	return nil, nil, errMmapUnsupported
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	// corrupted %s stream at input offset %#x
+	errNRVCorrupted = "corrupted %s stream at input offset %#x"
+)
+
+// nrvBits reads the control bits of an NRV stream, which are interleaved with
+// literal and offset bytes. The bits are grouped in bytes, or in little-endian
+// 16 or 32-bit words for the LE16 and LE32 variants.
+type nrvBits struct {
+	src   []byte
+	pos   int
+	width uint // 8, 16 or 32
+
+	bb uint32
+	bc uint
+}
+
+func (b *nrvBits) bit() uint32 {
+	if b.bc == 0 {
+		n := int(b.width / 8)
+		if b.pos+n > len(b.src) {
+			panic(io.ErrUnexpectedEOF)
+		}
+		switch b.width {
+		case 32:
+			b.bb = binary.LittleEndian.Uint32(b.src[b.pos:])
+		case 16:
+			b.bb = uint32(binary.LittleEndian.Uint16(b.src[b.pos:]))
+		default:
+			b.bb = uint32(b.src[b.pos])
+		}
+		b.pos += n
+		b.bc = b.width
+	}
+	b.bc--
+	return (b.bb >> b.bc) & 1
+}
+
+func (b *nrvBits) byte() uint32 {
+	if b.pos >= len(b.src) {
+		panic(io.ErrUnexpectedEOF)
+	}
+	b.pos++
+	return uint32(b.src[b.pos-1])
+}
+
+// nrvDecompress decompresses an NRV2B, NRV2D or NRV2E stream ("2b", "2d" or
+// "2e") of the UCL library, whose control bits are grouped by width, into dst
+// sized to the uncompressed length. Returns the number of bytes written.
+// reference: ucl/src/n2b_d.c, n2d_d.c, n2e_d.c
+func nrvDecompress(variant string, width uint, src, dst []byte) (n int, err error) {
+	b := &nrvBits{src: src, width: width}
+	olen := 0
+
+	defer func() {
+		if r := recover(); r != nil {
+			// slice bounds or input overruns of a corrupted stream
+			n, err = olen, fmt.Errorf(errNRVCorrupted, "NRV"+variant, b.pos)
+		}
+	}()
+
+	lastOff := uint32(1)
+	for {
+		for b.bit() == 1 {
+			dst[olen] = byte(b.byte())
+			olen++
+		}
+
+		off := uint32(1)
+		var length uint32
+
+		switch variant {
+		case "2b":
+			for {
+				off = off*2 + b.bit()
+				if b.bit() == 1 {
+					break
+				}
+			}
+		default:
+			for {
+				off = off*2 + b.bit()
+				if b.bit() == 1 {
+					break
+				}
+				off = (off-1)*2 + b.bit()
+			}
+		}
+
+		if off == 2 {
+			off = lastOff
+			if variant != "2b" {
+				length = b.bit()
+			}
+		} else {
+			off = (off-3)*256 + b.byte()
+			if off == 0xffffffff {
+				// end of stream marker
+				return olen, nil
+			}
+			if variant != "2b" {
+				length = (off ^ 0xffffffff) & 1
+				off >>= 1
+			}
+			off++
+			lastOff = off
+		}
+
+		switch variant {
+		case "2b":
+			length = b.bit()
+			length = length*2 + b.bit()
+			if length == 0 {
+				length = nrvGamma(b, 1) + 2
+			}
+			if off > 0xd00 {
+				length++
+			}
+		case "2d":
+			length = length*2 + b.bit()
+			if length == 0 {
+				length = nrvGamma(b, 1) + 2
+			}
+			if off > 0x500 {
+				length++
+			}
+		case "2e":
+			switch {
+			case length != 0:
+				length = 1 + b.bit()
+			case b.bit() == 1:
+				length = 3 + b.bit()
+			default:
+				length = nrvGamma(b, 1) + 3
+			}
+			if off > 0x500 {
+				length++
+			}
+		}
+
+		// the match is length+1 bytes long and may overlap its output
+		if uint32(olen) < off {
+			return olen, fmt.Errorf(errNRVCorrupted, "NRV"+variant, b.pos)
+		}
+		for i := uint32(0); i <= length; i++ {
+			dst[olen] = dst[olen-int(off)]
+			olen++
+		}
+	}
+}
+
+// nrvGamma reads an Elias gamma like encoded integer starting from v
+func nrvGamma(b *nrvBits, v uint32) uint32 {
+	for {
+		v = v*2 + b.bit()
+		if b.bit() == 1 {
+			return v
+		}
+	}
+}
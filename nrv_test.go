@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// nrvPlain has literals, a match reusing the last offset, an overlapping run
+// and a match further than the offsets adding a byte to the length
+var nrvPlain = []byte("ABCDEFGH1ABCDEFGH2ABCDEFGH" + strings.Repeat("a", 40) +
+	"gorip:" + strings.Repeat("z", 0xe00) + "gorip:embed0123456789")
+
+// streams of nrvPlain compressed with every variant and bit width
+var nrvVectors = []struct {
+	variant string
+	width   uint
+	method  uint8
+	src     string
+}{
+	{"2b", 8, UPX_M_NRV2B_8, "ff4142434445464748b03108e4323b6100021f676f7269e4703a7aaa0a324045080c057f656d62656430ff31323334353637388039000000000240ff"},
+	{"2b", 16, UPX_M_NRV2B_LE16, "b0ff414243444546474831083be43261001f02676f7269aae4703a7a320a45400c0805ff7f656d626564303132333435363738008039000002000040ff"},
+	{"2b", 32, UPX_M_NRV2B_LE32, "3be4b0ff41424344454647483108326100aae41f02676f7269703a7a4540320aff7f0c0805656d626564303132333435363738000000803900400200ff"},
+	{"2d", 8, UPX_M_NRV2D_8, "ff4142434445464748b13111c832766101087f676f7269703a927aa828c9011428730b65ff6d62656430313233fc34353637383924924924a8ff"},
+	{"2d", 16, UPX_M_NRV2D_LE16, "b1ff4142434445464748311176c83261017f08676f7269703aa8927ac928140173280b65fcff6d626564303132333435363738399224244900a8ff"},
+	{"2d", 32, UPX_M_NRV2D_LE32, "76c8b1ff41424344454647483111326101a8927f08676f7269703a7a1401c928fcff73280b656d6265643031323334353637383924499224000000a8ff"},
+	{"2e", 8, UPX_M_NRV2E_8, "ff4142434445464748b03111c83236610102ff676f7269703a927aa828490111687f0b656dff6265643031323334f835363738394924924950ff"},
+	{"2e", 16, UPX_M_NRV2E_LE16, "b0ff4142434445464748311136c8326101ff02676f7269703aa8927a492811017f680b656df8ff62656430313233343536373839244949920050ff"},
+	{"2e", 32, UPX_M_NRV2E_LE32, "36c8b0ff41424344454647483111326101a892ff02676f7269703a7a11014928f8ff7f680b656d626564303132333435363738394992244900000050ff"},
+}
+
+func TestNRVDecompress(t *testing.T) {
+	for _, v := range nrvVectors {
+		src, _ := hex.DecodeString(v.src)
+
+		dst := make([]byte, len(nrvPlain))
+		n, err := nrvDecompress(v.variant, v.width, src, dst)
+		if err != nil {
+			t.Errorf("NRV%s/%d: %v", v.variant, v.width, err)
+			continue
+		}
+		if n != len(nrvPlain) || !bytes.Equal(dst, nrvPlain) {
+			t.Errorf("NRV%s/%d: decompressed %d bytes, contents differ", v.variant, v.width, n)
+		}
+
+		// the method numbers of UPX select the same decoder
+		data, err := upxDecompress(v.method, src, uint32(len(nrvPlain)))
+		if err != nil || !bytes.Equal(data, nrvPlain) {
+			t.Errorf("method %d: %v", v.method, err)
+		}
+	}
+}
+
+func TestNRVTruncated(t *testing.T) {
+	for _, v := range nrvVectors {
+		src, _ := hex.DecodeString(v.src)
+
+		for _, n := range []int{0, 1, len(src) / 2, len(src) - 1} {
+			dst := make([]byte, len(nrvPlain))
+			if _, err := nrvDecompress(v.variant, v.width, src[:n], dst); err == nil {
+				t.Errorf("NRV%s/%d: %d of %d bytes decompressed without error", v.variant, v.width, n, len(src))
+			}
+		}
+	}
+}
+
+func TestNRVCorrupted(t *testing.T) {
+	// a match of the initial offset of 1 before any output
+	src := []byte{0x00, 0x00, 0x00, 0x20, 0xff, 0xff, 0xff, 0xff}
+	if _, err := nrvDecompress("2b", 32, src, make([]byte, 16)); err == nil {
+		t.Error("match before the start of the output decompressed without error")
+	}
+
+	// output longer than the destination
+	src, _ = hex.DecodeString(nrvVectors[0].src)
+	if _, err := nrvDecompress("2b", 8, src, make([]byte, 16)); err == nil {
+		t.Error("overflowing stream decompressed without error")
+	}
+
+	if _, err := upxDecompress(UPX_M_NRV2B_8, src, 16); err == nil {
+		t.Error("block larger than its uncompressed size decompressed without error")
+	}
+}
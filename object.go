@@ -0,0 +1,383 @@
+package main
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+var (
+	// object file has no data sections
+	errObjectData = "object file has no data sections"
+	// object file data sections too large
+	errObjectSize = "object file data sections too large"
+)
+
+// objectSection is a data section of a relocatable object placed in memory
+type objectSection struct {
+	addr   uint64
+	size   uint64
+	offset uint64 // file offset of the contents
+}
+
+// objectView copies the data sections of an object into a single zero-filled
+// view of memory, where relocations are applied before scanning
+func objectView(r io.ReaderAt, sections []*objectSection) ([]byte, uint64, error) {
+	if len(sections) == 0 {
+		return nil, 0, fmt.Errorf(errObjectData)
+	}
+
+	start, end := sections[0].addr, sections[0].addr+sections[0].size
+	for _, s := range sections[1:] {
+		start, end = min(start, s.addr), max(end, s.addr+s.size)
+	}
+	if end-start > uint64(MAX_FILE_SIZE) {
+		return nil, 0, fmt.Errorf(errObjectSize)
+	}
+
+	memory := make([]byte, end-start)
+	for _, s := range sections {
+		if _, err := r.ReadAt(memory[s.addr-start:s.addr-start+s.size], int64(s.offset)); err != nil {
+			return nil, 0, err
+		}
+	}
+	return memory, start, nil
+}
+
+// relocatedSection describes a relocated view of an object. File offsets of the
+// view are its addresses.
+func relocatedSection(name string, memory []byte, start uint64, order binary.ByteOrder, ptrsz int) *SectionData {
+	sd := &SectionData{
+		Name: name,
+
+		VirtualAddr: start,
+		VirtualSize: uint64(len(memory)),
+		FileOffset:  start,
+		FileSize:    uint64(len(memory)),
+
+		Order: order,
+		Ptrsz: ptrsz,
+	}
+	sd.Load(memory)
+	return sd
+}
+
+// exeELFObject is a relocatable ELF object, such as the go.o member of a Go
+// c-archive. Sections of an object are all located at address 0 and pointers
+// between them are only written by the linker, so the data sections are laid
+// out at their file offsets and their absolute relocations applied.
+type exeELFObject struct {
+	f *elf.File
+	r io.ReaderAt
+}
+
+func (x *exeELFObject) FormatName() string { return "ELF relocatable" }
+
+func (x *exeELFObject) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, ".rodata")
+}
+
+func (x *exeELFObject) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+func (x *exeELFObject) Sections() ([]*SectionData, error) {
+	psize, err := elfPtrsz(x.f)
+	if err != nil {
+		return nil, err
+	}
+
+	// sections without contents are placed after the end of the file
+	addrs := make([]uint64, len(x.f.Sections))
+	next := uint64(0)
+	for _, s := range x.f.Sections {
+		next = max(next, s.Offset+s.FileSize)
+	}
+
+	data := []*objectSection{}
+	for i, s := range x.f.Sections {
+		if s.Flags&elf.SHF_ALLOC == 0 {
+			continue
+		}
+		if s.Type == elf.SHT_NOBITS {
+			addrs[i] = next
+			next += s.Size
+			continue
+		}
+
+		addrs[i] = s.Offset
+		// code is not scanned, and compressed sections can not be laid out
+		if s.Flags&(elf.SHF_EXECINSTR|elf.SHF_COMPRESSED) == 0 && s.Size > 0 {
+			data = append(data, &objectSection{addr: s.Offset, size: s.Size, offset: s.Offset})
+		}
+	}
+
+	memory, start, err := objectView(x.r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	symbols, err := x.f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := 0
+	for _, s := range x.f.Sections {
+		if s.Type != elf.SHT_RELA && s.Type != elf.SHT_REL || int(s.Info) >= len(x.f.Sections) {
+			continue
+		}
+		target := x.f.Sections[s.Info]
+		if target.Flags&elf.SHF_ALLOC == 0 || target.Flags&elf.SHF_EXECINSTR != 0 {
+			continue
+		}
+
+		relocs, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		applied += x.relocate(memory, addrs[s.Info]-start, relocs, s.Type == elf.SHT_RELA, symbols, addrs)
+	}
+	slog.Debug("Applied relocations", "count", applied)
+
+	return []*SectionData{relocatedSection("relocated data", memory, start, x.f.ByteOrder, psize)}, nil
+}
+
+// relocate applies the absolute relocations of a section located at base in
+// memory. Returns the number of relocations applied.
+func (x *exeELFObject) relocate(memory []byte, base uint64, relocs []byte, rela bool, symbols []elf.Symbol, addrs []uint64) int {
+	order := x.f.ByteOrder
+
+	entsize := 16
+	if x.f.Class == elf.ELFCLASS32 {
+		entsize = 8
+	}
+	if rela {
+		entsize += entsize / 2
+	}
+
+	applied := 0
+	for b := relocs; len(b) >= entsize; b = b[entsize:] {
+		var offset, sym uint64
+		var typ uint32
+		var addend int64
+
+		if x.f.Class == elf.ELFCLASS32 {
+			info := order.Uint32(b[4:8])
+			offset, sym, typ = uint64(order.Uint32(b[0:4])), uint64(elf.R_SYM32(info)), elf.R_TYPE32(info)
+			if rela {
+				addend = int64(int32(order.Uint32(b[8:12])))
+			}
+		} else {
+			info := order.Uint64(b[8:16])
+			offset, sym, typ = order.Uint64(b[0:8]), uint64(elf.R_SYM64(info)), elf.R_TYPE64(info)
+			if rela {
+				addend = int64(order.Uint64(b[16:24]))
+			}
+		}
+
+		size := elfAbsRelocSize(x.f.Machine, typ)
+		at := base + offset
+		if size == 0 || sym == 0 || sym > uint64(len(symbols)) || at+uint64(size) > uint64(len(memory)) {
+			continue
+		}
+
+		s := symbols[sym-1]
+		var value uint64
+		switch {
+		case s.Section == elf.SHN_ABS:
+			value = s.Value
+		case s.Section > elf.SHN_UNDEF && int(s.Section) < len(addrs):
+			value = addrs[s.Section] + s.Value
+		default:
+			// undefined symbols are resolved by the final link
+			continue
+		}
+
+		p := memory[at : at+uint64(size)]
+		if size == 8 {
+			if !rela {
+				addend = int64(order.Uint64(p))
+			}
+			order.PutUint64(p, value+uint64(addend))
+		} else {
+			if !rela {
+				addend = int64(int32(order.Uint32(p)))
+			}
+			order.PutUint32(p, uint32(value+uint64(addend)))
+		}
+		applied++
+	}
+	return applied
+}
+
+// elfAbsRelocSize returns the size of the absolute address written by a
+// relocation type, or 0 for other relocations
+func elfAbsRelocSize(machine elf.Machine, typ uint32) int {
+	switch machine {
+	case elf.EM_X86_64:
+		switch elf.R_X86_64(typ) {
+		case elf.R_X86_64_64:
+			return 8
+		case elf.R_X86_64_32, elf.R_X86_64_32S:
+			return 4
+		}
+	case elf.EM_AARCH64:
+		switch elf.R_AARCH64(typ) {
+		case elf.R_AARCH64_ABS64:
+			return 8
+		case elf.R_AARCH64_ABS32:
+			return 4
+		}
+	case elf.EM_386:
+		if elf.R_386(typ) == elf.R_386_32 {
+			return 4
+		}
+	case elf.EM_ARM:
+		if elf.R_ARM(typ) == elf.R_ARM_ABS32 {
+			return 4
+		}
+	case elf.EM_PPC64:
+		switch elf.R_PPC64(typ) {
+		case elf.R_PPC64_ADDR64:
+			return 8
+		case elf.R_PPC64_ADDR32:
+			return 4
+		}
+	case elf.EM_RISCV:
+		switch elf.R_RISCV(typ) {
+		case elf.R_RISCV_64:
+			return 8
+		case elf.R_RISCV_32:
+			return 4
+		}
+	case elf.EM_S390:
+		switch elf.R_390(typ) {
+		case elf.R_390_64:
+			return 8
+		case elf.R_390_32:
+			return 4
+		}
+	case elf.EM_LOONGARCH:
+		switch elf.R_LARCH(typ) {
+		case elf.R_LARCH_64:
+			return 8
+		case elf.R_LARCH_32:
+			return 4
+		}
+	}
+	return 0
+}
+
+// exeMachOObject is a Mach-O object (MH_OBJECT). Unlike ELF, the sections of
+// an object are assigned distinct addresses, pointers to external symbols are
+// written by the linker.
+type exeMachOObject struct {
+	f *macho.File
+	r io.ReaderAt
+}
+
+func (x *exeMachOObject) FormatName() string { return "Mach-O object" }
+
+func (x *exeMachOObject) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, "__rodata")
+}
+
+func (x *exeMachOObject) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+const (
+	// S_ATTR_PURE_INSTRUCTIONS | S_ATTR_SOME_INSTRUCTIONS
+	MACHO_SECTION_CODE = 0x80000400
+	// S_ZEROFILL, sections of this type have no contents in the file
+	MACHO_SECTION_ZEROFILL = 0x1
+	MACHO_SECTION_TYPE     = 0xff
+)
+
+func (x *exeMachOObject) Sections() ([]*SectionData, error) {
+	psize := 8
+	if x.f.Cpu == macho.Cpu386 || x.f.Cpu == macho.CpuArm {
+		psize = 4
+	}
+
+	data := []*objectSection{}
+	scanned := []*macho.Section{}
+	for _, s := range x.f.Sections {
+		if s.Seg == "__DWARF" || s.Flags&MACHO_SECTION_CODE != 0 ||
+			s.Flags&MACHO_SECTION_TYPE == MACHO_SECTION_ZEROFILL || s.Size == 0 {
+			continue
+		}
+		data = append(data, &objectSection{addr: s.Addr, size: s.Size, offset: uint64(s.Offset)})
+		scanned = append(scanned, s)
+	}
+
+	memory, start, err := objectView(x.r, data)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := 0
+	for _, s := range scanned {
+		applied += x.relocate(memory, s.Addr-start, s.Relocs)
+	}
+	slog.Debug("Applied relocations", "count", applied)
+
+	return []*SectionData{relocatedSection("relocated data", memory, start, x.f.ByteOrder, psize)}, nil
+}
+
+// relocate applies the unsigned relocations to external symbols of a section
+// located at base in memory. Local relocations already hold the address of
+// their target. Returns the number of relocations applied.
+func (x *exeMachOObject) relocate(memory []byte, base uint64, relocs []macho.Reloc) int {
+	order := x.f.ByteOrder
+	applied := 0
+
+	for _, r := range relocs {
+		// *_RELOC_UNSIGNED is 0 on every architecture
+		if r.Type != 0 || r.Pcrel || r.Scattered || !r.Extern || x.f.Symtab == nil || int(r.Value) >= len(x.f.Symtab.Syms) {
+			continue
+		}
+
+		s := x.f.Symtab.Syms[r.Value]
+		// N_TYPE == N_UNDF, resolved by the final link
+		if s.Type&0x0e == 0 {
+			continue
+		}
+
+		at := base + uint64(r.Addr)
+		switch r.Len {
+		case 3:
+			if at+8 > uint64(len(memory)) {
+				continue
+			}
+			p := memory[at : at+8]
+			order.PutUint64(p, s.Value+order.Uint64(p))
+		case 2:
+			if at+4 > uint64(len(memory)) {
+				continue
+			}
+			p := memory[at : at+4]
+			order.PutUint32(p, uint32(s.Value)+order.Uint32(p))
+		default:
+			continue
+		}
+		applied++
+	}
+	return applied
+}
+
+// isGoObject reports whether r is a relocatable object produced by the Go
+// linker, whose build information is not readable before the final link
+func isGoObject(r io.ReaderAt) bool {
+	if f, err := elf.NewFile(r); err == nil {
+		return f.Type == elf.ET_REL && f.Section(".go.buildinfo") != nil
+	}
+	if f, err := macho.NewFile(r); err == nil {
+		return f.Type == macho.TypeObj && f.Section("__go_buildinfo") != nil
+	}
+	return false
+}
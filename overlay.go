@@ -0,0 +1,318 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+var (
+	// overlay detection is not supported for %s files
+	errOverlayFormat = "overlay detection is not supported for %s files"
+	// overlay detection requires a file, not a process
+	errOverlayProcess = "overlay detection requires a file, not a process"
+)
+
+const (
+	// IMAGE_DIRECTORY_ENTRY_SECURITY, its address is a file offset
+	PE_DIRECTORY_SECURITY = 4
+	PE_SYMBOL_SIZE        = 18
+	XCOFF64_SYMBOL_SIZE   = 18
+
+	// nested archives listed at most this deep
+	MAX_OVERLAY_ZIP_DEPTH = 4
+)
+
+// Overlay is data appended after the end of the image of an executable, such
+// as the zip archive of older embedding libraries
+type Overlay struct {
+	Offset  int64
+	Size    int64
+	Type    string
+	Entropy float64 // bits per byte
+
+	r io.ReaderAt
+}
+
+// Open returns a reader of the overlay contents
+func (ov *Overlay) Open() *io.SectionReader {
+	return io.NewSectionReader(ov.r, ov.Offset, ov.Size)
+}
+
+// imageEnd returns the file offset following the last byte of x described by
+// its headers: the sections or segments, the header tables and the symbol
+// tables. Signatures of PE executables are part of the image.
+func imageEnd(x exe, r io.ReaderAt) (int64, error) {
+	end := uint64(0)
+
+	switch x := x.(type) {
+	case *exeELF:
+		for _, s := range x.f.Sections {
+			if s.Type != elf.SHT_NOBITS {
+				end = max(end, s.Offset+s.FileSize)
+			}
+		}
+		for _, p := range x.f.Progs {
+			end = max(end, p.Off+p.Filesz)
+		}
+
+		// e_shoff, e_shentsize and e_shnum
+		hdr := make([]byte, 64)
+		if _, err := r.ReadAt(hdr, 0); err != nil {
+			return 0, err
+		}
+		order := x.f.ByteOrder
+		if x.f.Class == elf.ELFCLASS32 {
+			end = max(end, uint64(order.Uint32(hdr[0x20:]))+uint64(order.Uint16(hdr[0x2e:]))*uint64(order.Uint16(hdr[0x30:])))
+		} else {
+			end = max(end, order.Uint64(hdr[0x28:])+uint64(order.Uint16(hdr[0x3a:]))*uint64(order.Uint16(hdr[0x3c:])))
+		}
+
+	case *exePE:
+		for _, s := range x.f.Sections {
+			end = max(end, uint64(s.Offset)+uint64(s.Size))
+		}
+
+		var dirs []pe.DataDirectory
+		switch oh := x.f.OptionalHeader.(type) {
+		case *pe.OptionalHeader32:
+			end, dirs = max(end, uint64(oh.SizeOfHeaders)), oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+		case *pe.OptionalHeader64:
+			end, dirs = max(end, uint64(oh.SizeOfHeaders)), oh.DataDirectory[:min(oh.NumberOfRvaAndSizes, 16)]
+		}
+		if len(dirs) > PE_DIRECTORY_SECURITY {
+			d := dirs[PE_DIRECTORY_SECURITY]
+			end = max(end, uint64(d.VirtualAddress)+uint64(d.Size))
+		}
+
+		// the COFF string table follows the symbols, starting with its size
+		if fh := x.f.FileHeader; fh.PointerToSymbolTable != 0 {
+			strtab := uint64(fh.PointerToSymbolTable) + uint64(fh.NumberOfSymbols)*PE_SYMBOL_SIZE
+			end = max(end, strtab+uint64(readUint32(r, int64(strtab), binary.LittleEndian)))
+		}
+
+	case *exeMACHO:
+		// __LINKEDIT holds the symbols and the code signature, the data of
+		// linkedit_data_command load commands is checked as well
+		for _, l := range x.f.Loads {
+			if s, ok := l.(*macho.Segment); ok {
+				end = max(end, s.Offset+s.Filesz)
+				continue
+			}
+			raw := l.Raw()
+			if len(raw) >= 16 && machoLinkeditData[x.f.ByteOrder.Uint32(raw)] {
+				end = max(end, uint64(x.f.ByteOrder.Uint32(raw[8:]))+uint64(x.f.ByteOrder.Uint32(raw[12:])))
+			}
+		}
+
+	case *exePlan9:
+		// text, data and the symbol and pc tables
+		for _, s := range x.f.Sections {
+			end = max(end, uint64(s.Offset)+uint64(s.Size))
+		}
+
+	case *exeXCOFF:
+		for _, s := range x.sections {
+			end = max(end, s.Offset+s.Size)
+		}
+
+		// f_symptr and f_nsyms, the string table follows the symbols
+		hdr := make([]byte, XCOFF64_FILE_HEADER_SIZE)
+		if _, err := r.ReadAt(hdr, 0); err != nil {
+			return 0, err
+		}
+		if symptr := binary.BigEndian.Uint64(hdr[8:16]); symptr != 0 {
+			strtab := symptr + uint64(binary.BigEndian.Uint32(hdr[20:24]))*XCOFF64_SYMBOL_SIZE
+			end = max(end, strtab+uint64(readUint32(r, int64(strtab), binary.BigEndian)))
+		}
+
+	default:
+		return 0, fmt.Errorf(errOverlayFormat, x.FormatName())
+	}
+
+	return int64(end), nil
+}
+
+// machoLinkeditData lists the load commands described by a linkedit_data_command:
+// code signature, split info, function starts, data in code, code signing DRs,
+// linker optimization hints, exports trie and chained fixups
+var machoLinkeditData = map[uint32]bool{
+	0x1d: true, 0x1e: true, 0x26: true, 0x29: true, 0x2b: true, 0x2e: true,
+	0x80000033: true, LC_DYLD_CHAINED_FIXUPS: true,
+}
+
+// readUint32 reads the integer at offset of r, or 0 when it can not be read
+func readUint32(r io.ReaderAt, offset int64, order binary.ByteOrder) uint32 {
+	b := make([]byte, 4)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return 0
+	}
+	return order.Uint32(b)
+}
+
+// findOverlay returns the data of r, which is size bytes long, following the
+// image of x, or nil when the file ends with the image
+func findOverlay(x exe, r io.ReaderAt, size int64) (*Overlay, error) {
+	end, err := imageEnd(x, r)
+	if err != nil {
+		return nil, err
+	}
+	if end >= size {
+		return nil, nil
+	}
+
+	ov := &Overlay{Offset: end, Size: size - end, r: r}
+	ov.Type = overlayType(ov.Open())
+	if ov.Entropy, err = entropy(ov.Open()); err != nil {
+		return nil, err
+	}
+	return ov, nil
+}
+
+// overlayMagics recognizes common appended data by its leading bytes
+var overlayMagics = []struct {
+	Name  string
+	Magic string
+}{
+	{"zip archive", "PK\x03\x04"},
+	{"empty zip archive", "PK\x05\x06"},
+	{"7z archive", "7z\xbc\xaf\x27\x1c"},
+	{"rar archive", "Rar!\x1a\x07"},
+	{"PE executable", "MZ"},
+	{"ELF executable", "\x7fELF"},
+	{"PNG image", "\x89PNG\r\n\x1a\n"},
+	{"PDF document", "%PDF-"},
+	{"SQLite database", "SQLite format 3\x00"},
+}
+
+// overlayType names the format of the overlay, zip archives are recognized by
+// their central directory even when preceded by other data
+func overlayType(r *io.SectionReader) string {
+	head := make([]byte, 512)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	if c := detectCompression(head); c != nil {
+		return c.Name + " compressed data"
+	}
+	for _, m := range overlayMagics {
+		if bytes.HasPrefix(head, []byte(m.Magic)) {
+			return m.Name
+		}
+	}
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return "tar archive"
+	}
+	if _, err := zip.NewReader(r, r.Size()); err == nil {
+		return "zip archive (with prefix)"
+	}
+	if len(head) > 0 && bytes.IndexByte(head, 0) < 0 {
+		return "text"
+	}
+	if len(bytes.Trim(head, "\x00")) == 0 {
+		return "zero padding"
+	}
+	return "unknown data"
+}
+
+// entropy returns the Shannon entropy of the contents of r in bits per byte
+func entropy(r io.Reader) (float64, error) {
+	counts := [256]uint64{}
+	total := uint64(0)
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			counts[b]++
+		}
+		total += uint64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	e := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(total)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e, nil
+}
+
+// writeOverlayInfo outputs the location, type and entropy of the overlay, and
+// the entries of zip archives
+func writeOverlayInfo(writer io.Writer, ov *Overlay) error {
+	fmt.Fprintf(writer, "Overlay:\n")
+	fmt.Fprintf(writer, "  - File offset: %#x\n", ov.Offset)
+	fmt.Fprintf(writer, "  - Size: %d (%#[1]x)\n", ov.Size)
+	fmt.Fprintf(writer, "  - Type: %s\n", ov.Type)
+	fmt.Fprintf(writer, "  - Entropy: %.2f bits/byte\n", ov.Entropy)
+
+	r := ov.Open()
+	z, err := zip.NewReader(r, r.Size())
+	if err != nil {
+		return nil
+	}
+	fmt.Fprintf(writer, "Entries:\n")
+	return listZip(writer, z, 1)
+}
+
+// listZip outputs the entries of a zip archive, descending into the archives
+// it contains
+func listZip(writer io.Writer, z *zip.Reader, depth int) error {
+	indent := strings.Repeat("  ", depth)
+	for _, f := range z.File {
+		fmt.Fprintf(writer, "%s%9d %s\n", indent, f.UncompressedSize64, f.Name)
+
+		if f.FileInfo().IsDir() || depth >= MAX_OVERLAY_ZIP_DEPTH || f.UncompressedSize64 > uint64(MAX_FILE_SIZE) {
+			continue
+		}
+		data, err := readNestedZip(f)
+		if err != nil {
+			fmt.Fprintf(writer, "%s  [!] %v\n", indent, err)
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		if nested, err := zip.NewReader(bytes.NewReader(data), int64(len(data))); err == nil {
+			if err := listZip(writer, nested, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readNestedZip returns the contents of a zip entry when it is a zip archive
+// itself, or nil
+func readNestedZip(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(rc, magic); err != nil || string(magic) != "PK\x03\x04" {
+		return nil, nil
+	}
+	rest, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return append(magic, rest...), nil
+}
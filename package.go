@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+var (
+	// deb package has no data archive
+	errDebData = "deb package has no data archive"
+	// malformed rpm header at %#x
+	errRPMHeader = "malformed rpm header at %#x"
+	// rpm payload: %w
+	errRPMPayload = "rpm payload: %w"
+)
+
+const (
+	ZIP_MAGIC = "PK\x03\x04"
+	RPM_MAGIC = "\xed\xab\xee\xdb"
+
+	// the rpm lead is followed by the signature and the main header
+	RPM_LEAD_SIZE         = 96
+	RPM_HEADER_MAGIC      = "\x8e\xad\xe8\x01"
+	RPM_HEADER_SIZE       = 16
+	RPM_INDEX_ENTRY_SIZE  = 16
+	RPM_MAX_HEADER_LENGTH = 256 * 1024 * 1024
+)
+
+// ZipPackage is a zip based package, such as an Android APK (gomobile places
+// the Go code in lib/<abi>/libgojni.so) or a Java JAR
+type ZipPackage struct {
+	r *zip.Reader
+}
+
+func openZip(f *os.File) (Container, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	return &ZipPackage{r}, nil
+}
+
+func (z *ZipPackage) FormatName() string {
+	for _, f := range z.r.File {
+		switch f.Name {
+		case "AndroidManifest.xml":
+			return "APK"
+		case "META-INF/MANIFEST.MF":
+			return "JAR"
+		}
+	}
+	return "zip archive"
+}
+
+func (z *ZipPackage) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	for _, f := range z.r.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, int64(f.UncompressedSize64), r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTar calls fn for every regular file of a possibly compressed tarball.
+// Names are made absolute since packages store them relative to the root.
+func walkTar(r io.Reader, fn func(name string, size int64, r io.Reader) error) error {
+	dr, _, err := decompressReader(r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+
+	tr := tar.NewReader(dr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(path.Join("/", hdr.Name), hdr.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// DebPackage is a Debian package, an ar archive whose data.tar.* member holds
+// the installed files
+type DebPackage struct {
+	data *io.SectionReader
+}
+
+// newDebPackage locates the data archive among the members of a deb package
+func newDebPackage(f *os.File, members []*ArMember) (Container, error) {
+	for _, m := range members {
+		if strings.HasPrefix(m.Name, "data.tar") {
+			return &DebPackage{io.NewSectionReader(f, m.Offset, m.Size)}, nil
+		}
+	}
+	return nil, fmt.Errorf(errDebData)
+}
+
+func (d *DebPackage) FormatName() string { return "deb package" }
+
+func (d *DebPackage) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	return walkTar(io.NewSectionReader(d.data, 0, d.data.Size()), fn)
+}
+
+// RPMPackage is an rpm package, the payload following the headers is a
+// compressed cpio archive
+type RPMPackage struct {
+	payload *io.SectionReader
+}
+
+// rpmHeaderSize returns the size of the header structure at offset
+// reference: https://rpm-software-management.github.io/rpm/manual/format_v4.html
+func rpmHeaderSize(r io.ReaderAt, offset int64) (int64, error) {
+	b := make([]byte, RPM_HEADER_SIZE)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return 0, err
+	}
+	if string(b[:4]) != RPM_HEADER_MAGIC {
+		return 0, fmt.Errorf(errRPMHeader, offset)
+	}
+
+	count := int64(binary.BigEndian.Uint32(b[8:12]))
+	length := int64(binary.BigEndian.Uint32(b[12:16]))
+	if count*RPM_INDEX_ENTRY_SIZE+length > RPM_MAX_HEADER_LENGTH {
+		return 0, fmt.Errorf(errRPMHeader, offset)
+	}
+
+	return RPM_HEADER_SIZE + count*RPM_INDEX_ENTRY_SIZE + length, nil
+}
+
+func openRPM(f *os.File) (Container, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := int64(RPM_LEAD_SIZE)
+	n, err := rpmHeaderSize(f, offset)
+	if err != nil {
+		return nil, err
+	}
+	// the signature header is padded to 8 bytes
+	offset += n + (8-n%8)%8
+
+	if n, err = rpmHeaderSize(f, offset); err != nil {
+		return nil, err
+	}
+	offset += n
+	if offset > info.Size() {
+		return nil, fmt.Errorf(errRPMHeader, offset)
+	}
+
+	return &RPMPackage{io.NewSectionReader(f, offset, info.Size()-offset)}, nil
+}
+
+func (p *RPMPackage) FormatName() string { return "rpm package" }
+
+func (p *RPMPackage) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	dr, c, err := decompressReader(io.NewSectionReader(p.payload, 0, p.payload.Size()))
+	if err != nil {
+		return fmt.Errorf(errRPMPayload, err)
+	}
+	defer dr.Close()
+
+	// compressed payloads are bounded like any decompressed input
+	size := p.payload.Size()
+	if c != nil {
+		size = MAX_INPUT_SIZE
+	}
+
+	cr := NewCpioReader(dr, size)
+	for {
+		hdr, err := cr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf(errRPMPayload, err)
+		}
+		// hard linked files only store their contents in the last link
+		if !hdr.Regular() || hdr.Size == 0 {
+			continue
+		}
+		if err := fn(path.Join("/", hdr.Name), hdr.Size, cr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// arMember returns the header and contents of an ar member, padded to 2 bytes
+func arMember(name, data string) []byte {
+	b := fmt.Appendf(nil, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data))
+	b = append(b, data...)
+	if len(data)%2 != 0 {
+		b = append(b, '\n')
+	}
+	return b
+}
+
+// testFile writes b to a temporary file, opened for reading
+func testFile(t *testing.T, b []byte) *os.File {
+	name := filepath.Join(t.TempDir(), "package")
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
+
+// walkNames returns the names and contents of the files of c
+func walkNames(c Container) ([]string, error) {
+	names := []string{}
+	err := c.Walk(func(name string, size int64, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if int64(len(data)) != size {
+			return fmt.Errorf("%s: read %d bytes, want %d", name, len(data), size)
+		}
+		names = append(names, name+" "+string(data))
+		return err
+	})
+	return names, err
+}
+
+func TestReadArchive(t *testing.T) {
+	names := "a_very_long_member_name.o/\n"
+	archive := []byte(AR_MAGIC)
+	for _, m := range [][]byte{
+		arMember("/", "symbols"),
+		arMember("//", names),
+		arMember("/0", "long"),
+		arMember("#1/12", "bsd_name.o\x00\x00contents"),
+		arMember("go.o/", "odd"),
+	} {
+		archive = append(archive, m...)
+	}
+
+	members, err := readArchive(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, m := range members {
+		got = append(got, m.Name+" "+string(archive[m.Offset:m.Offset+m.Size]))
+	}
+	want := []string{"a_very_long_member_name.o long", "bsd_name.o contents", "go.o odd"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got members %q, want %q", got, want)
+	}
+
+	// contents past the end, a corrupt header, a name out of the table and a BSD
+	// name longer than the member
+	for _, b := range [][]byte{
+		archive[:len(archive)-2],
+		append([]byte(AR_MAGIC), bytes.Replace(arMember("a", "b"), []byte("`\n"), []byte("  "), 1)...),
+		append([]byte(AR_MAGIC), arMember("/99", "b")...),
+		append([]byte(AR_MAGIC), arMember("#1/12", "short")...),
+	} {
+		if _, err := readArchive(bytes.NewReader(b), int64(len(b))); err == nil {
+			t.Errorf("archive %q: no error", b)
+		}
+	}
+}
+
+// testTarGz returns a gzip compressed tarball of regular files, given as pairs
+// of name and contents
+func testTarGz(files ...string) []byte {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	tw := tar.NewWriter(zw)
+	tw.WriteHeader(&tar.Header{Name: "./usr/", Typeflag: tar.TypeDir, Mode: 0755})
+	for i := 0; i < len(files); i += 2 {
+		tw.WriteHeader(&tar.Header{Name: files[i], Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(files[i+1]))})
+		tw.Write([]byte(files[i+1]))
+	}
+	tw.Close()
+	zw.Close()
+	return b.Bytes()
+}
+
+func TestDebPackage(t *testing.T) {
+	deb := []byte(AR_MAGIC)
+	deb = append(deb, arMember("debian-binary", "2.0\n")...)
+	deb = append(deb, arMember("control.tar.gz", string(testTarGz("./control", "Package: tool")))...)
+	deb = append(deb, arMember("data.tar.gz", string(testTarGz("./usr/bin/tool", "hello")))...)
+
+	c, err := openAr(testFile(t, deb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.FormatName() != "deb package" {
+		t.Fatalf("opened as %s", c.FormatName())
+	}
+	names, err := walkNames(c)
+	if err != nil || !reflect.DeepEqual(names, []string{"/usr/bin/tool hello"}) {
+		t.Errorf("got files %q (%v)", names, err)
+	}
+}
+
+// rpmHeader returns the start of a header structure of count index entries and
+// length bytes of data
+func rpmHeader(count, length uint32) []byte {
+	b := []byte(RPM_HEADER_MAGIC + "\x00\x00\x00\x00")
+	b = binary.BigEndian.AppendUint32(b, count)
+	return binary.BigEndian.AppendUint32(b, length)
+}
+
+// testRPM returns a package of the signature and main headers followed by the
+// payload, the signature header being padded to 8 bytes
+func testRPM(signature, header, payload []byte) []byte {
+	lead := make([]byte, RPM_LEAD_SIZE)
+	copy(lead, RPM_MAGIC)
+
+	b := append(lead, signature...)
+	b = append(b, make([]byte, (8-len(signature)%8)%8)...)
+	b = append(b, header...)
+	return append(b, payload...)
+}
+
+func TestRPMPackage(t *testing.T) {
+	archive := testCpio(
+		cpioMember{name: "./usr/bin", mode: 040755},
+		cpioMember{name: "./usr/bin/tool", mode: 0100755, data: "hello"},
+		// hard link whose contents are stored in a later link
+		cpioMember{name: "./usr/bin/link", mode: 0100755},
+	)
+	var payload bytes.Buffer
+	zw := gzip.NewWriter(&payload)
+	zw.Write(archive)
+	zw.Close()
+
+	// a signature header of 37 bytes, padded, and a main header of 58 bytes
+	signature := append(rpmHeader(1, 5), make([]byte, 21)...)
+	header := append(rpmHeader(2, 10), make([]byte, 42)...)
+
+	for _, p := range [][]byte{archive, payload.Bytes()} {
+		c, err := openRPM(testFile(t, testRPM(signature, header, p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := walkNames(c)
+		if err != nil || !reflect.DeepEqual(names, []string{"/usr/bin/tool hello"}) {
+			t.Errorf("got files %q (%v)", names, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		rpm  []byte
+	}{
+		{"signature magic", testRPM(append([]byte("\x8e\xad\xe8\x02"), signature[4:]...), header, archive)},
+		{"header length", testRPM(signature, rpmHeader(0, RPM_MAX_HEADER_LENGTH+1), nil)},
+		{"header index", testRPM(rpmHeader(RPM_MAX_HEADER_LENGTH/RPM_INDEX_ENTRY_SIZE, 1), nil, nil)},
+		{"header past the end", testRPM(signature, rpmHeader(2, 0x1000), nil)},
+		{"truncated header", testRPM(signature, nil, nil)},
+	}
+	for _, tt := range tests {
+		if _, err := openRPM(testFile(t, tt.rpm)); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+
+	// contents past the end of an uncompressed payload
+	c, err := openRPM(testFile(t, testRPM(signature, header, archive[:len(archive)-0x80])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := walkNames(c); err == nil {
+		t.Error("truncated payload: no error")
+	}
+}
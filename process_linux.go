@@ -0,0 +1,199 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// process %d has no readable mappings of its executable
+	errProcessMappings = "process %d has no readable mappings of its executable"
+	// malformed mapping \"%s\"
+	errMapping = "malformed mapping \"%s\""
+)
+
+// Mapping is a line of /proc/<pid>/maps
+type Mapping struct {
+	Start, End uint64
+	Perms      string
+	Offset     uint64
+	Path       string
+}
+
+// Readable reports whether the mapping can be read through /proc/<pid>/mem
+func (m *Mapping) Readable() bool {
+	return len(m.Perms) > 0 && m.Perms[0] == 'r'
+}
+
+// reference: proc(5) /proc/pid/maps
+func readMappings(pid int) ([]*Mapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	mappings := []*Mapping{}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// address perms offset dev inode pathname, the path may contain spaces
+		fields := strings.SplitN(s.Text(), " ", 6)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf(errMapping, s.Text())
+		}
+
+		start, end, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf(errMapping, s.Text())
+		}
+
+		m := &Mapping{Perms: fields[1]}
+		if m.Start, err = strconv.ParseUint(start, 16, 64); err != nil {
+			return nil, fmt.Errorf(errMapping, s.Text())
+		}
+		if m.End, err = strconv.ParseUint(end, 16, 64); err != nil {
+			return nil, fmt.Errorf(errMapping, s.Text())
+		}
+		if m.Offset, err = strconv.ParseUint(fields[2], 16, 64); err != nil {
+			return nil, fmt.Errorf(errMapping, s.Text())
+		}
+		if len(fields) == 6 {
+			m.Path = strings.TrimLeft(fields[5], " ")
+		}
+
+		mappings = append(mappings, m)
+	}
+
+	return mappings, s.Err()
+}
+
+// executableRegions groups the readable mappings of the main executable into
+// runs of contiguous mappings. Anonymous mappings directly following them are
+// included, since that is where self-unpacking executables place their code and
+// data, and where the bss is mapped.
+func executableRegions(mappings []*Mapping, exe string) [][]*Mapping {
+	regions := [][]*Mapping{}
+	var last *Mapping
+
+	for _, m := range mappings {
+		path := strings.TrimSuffix(m.Path, " (deleted)")
+
+		owned := path == exe || path == "" && last != nil && last.End == m.Start
+		if !owned || !m.Readable() {
+			last = nil
+			continue
+		}
+
+		if last != nil && last.End == m.Start {
+			regions[len(regions)-1] = append(regions[len(regions)-1], m)
+		} else {
+			regions = append(regions, []*Mapping{m})
+		}
+		last = m
+	}
+
+	return regions
+}
+
+// exeProcess is the memory of a live process
+type exeProcess struct {
+	pid      int
+	sections []*SectionData
+}
+
+func (x *exeProcess) FormatName() string { return fmt.Sprintf("process %d", x.pid) }
+
+func (x *exeProcess) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, ".rodata")
+}
+
+func (x *exeProcess) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+func (x *exeProcess) Sections() ([]*SectionData, error) { return x.sections, nil }
+
+// openProcess snapshots the memory of the main executable of a running
+// process. Each region of contiguous mappings becomes a section, whose file
+// offsets are the offsets inside /proc/<pid>/mem, that is its virtual
+// addresses. Reading the memory of another process requires the same
+// permissions as ptrace(2).
+func openProcess(pid int, start time.Time) (*Target, error) {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil, err
+	}
+	exe = strings.TrimSuffix(exe, " (deleted)")
+
+	// the pointer size and byte order are those of the executable
+	ef, err := elf.Open(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return nil, err
+	}
+	ef.Close()
+
+	psize, err := elfPtrsz(ef)
+	if err != nil {
+		return nil, err
+	}
+
+	mappings, err := readMappings(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer mem.Close()
+
+	x := &exeProcess{pid: pid}
+	var image []byte
+
+	for _, region := range executableRegions(mappings, exe) {
+		base, end := region[0].Start, region[len(region)-1].End
+
+		contents := make([]byte, end-base)
+		if _, err := mem.ReadAt(contents, int64(base)); err != nil {
+			slog.Warn("Skipping unreadable region", "pid", pid, hexAttr("start", base), hexAttr("end", end), "err", err)
+			continue
+		}
+
+		sd := &SectionData{
+			Name: fmt.Sprintf("%s %#x", region[0].Perms, base),
+
+			VirtualAddr: base,
+			VirtualSize: end - base,
+			FileOffset:  base,
+			FileSize:    end - base,
+
+			Order: ef.ByteOrder,
+			Ptrsz: psize,
+		}
+		sd.Load(contents)
+		x.sections = append(x.sections, sd)
+
+		if image == nil {
+			image = contents
+		}
+	}
+	if len(x.sections) == 0 {
+		return nil, fmt.Errorf(errProcessMappings, pid)
+	}
+
+	// the first mapping of the executable starts with its header
+	t := &Target{File: bytes.NewReader(image), Exe: x, Sections: x.sections}
+	t.Timer.Track("open", start, 0)
+	return t, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+var errProcessUnsupported = errors.New("process scanning is only supported on Linux")
+
+func openProcess(pid int, start time.Time) (*Target, error) {
+	return nil, errProcessUnsupported
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minimum time between two progress updates
+	PROGRESS_INTERVAL time.Duration = 100 * time.Millisecond
+	// number of bytes scanned between two progress checks
+	PROGRESS_STEP uint64 = 1024 * 1024
+)
+
+// progressActive is set while a progress line is drawn on stderr, so log
+// records clear it before being written
+var progressActive atomic.Bool
+
+// Progress reports the advancement of a long running phase (bytes processed,
+// candidates found and an ETA) on a single, continuously rewritten line. All
+// methods are safe to call on a nil Progress, which reports nothing.
+type Progress struct {
+	w     io.Writer
+	label string
+	total uint64
+	done  uint64
+	base  uint64
+	found int
+
+	start time.Time
+	last  time.Time
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewProgress returns a progress reporter for a phase processing total bytes.
+// Nil is returned when progress is disabled or stderr is not a terminal, so
+// redirected output is not filled with progress lines.
+func NewProgress(label string, total uint64, enabled bool) *Progress {
+	if !enabled || !isTerminal(os.Stderr) {
+		return nil
+	}
+
+	now := time.Now()
+	return &Progress{w: os.Stderr, label: label, total: total, start: now, last: now}
+}
+
+// Add records n processed bytes
+func (p *Progress) Add(n uint64) {
+	if p == nil {
+		return
+	}
+	p.done += n
+	p.render()
+}
+
+// Set records the total number of processed bytes, relative to the offset
+// given to Start
+func (p *Progress) Set(n uint64) {
+	if p == nil {
+		return
+	}
+	p.done = p.base + n
+	p.render()
+}
+
+// Start marks the beginning of a part of the phase located n bytes in, used
+// when a phase processes several inputs
+func (p *Progress) Start(n uint64) {
+	if p == nil {
+		return
+	}
+	p.base = n
+	p.Set(0)
+}
+
+// Found records n newly found candidates
+func (p *Progress) Found(n int) {
+	if p == nil {
+		return
+	}
+	p.found += n
+}
+
+// Done clears the progress line
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+	fmt.Fprintf(p.w, "\r\033[K")
+	progressActive.Store(false)
+}
+
+func (p *Progress) render() {
+	now := time.Now()
+	if now.Sub(p.last) < PROGRESS_INTERVAL {
+		return
+	}
+	p.last = now
+
+	done := min(p.done, p.total)
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(done) * 100 / float64(p.total)
+	}
+
+	eta := "-"
+	if elapsed := now.Sub(p.start); done > 0 && done < p.total {
+		remaining := time.Duration(float64(elapsed) * float64(p.total-done) / float64(done))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	fmt.Fprintf(p.w, "\r\033[K[~] %s: %s/%s (%.0f%%) %d candidate(s) ETA %s",
+		p.label, humanSize(done), humanSize(p.total), percent, p.found, eta)
+	progressActive.Store(true)
+}
+
+// Phase is the duration of a step of a command and the bytes it processed
+type Phase struct {
+	Name    string
+	Elapsed time.Duration
+	Bytes   uint64
+}
+
+// PhaseTimer records how long each step of a command took
+type PhaseTimer struct {
+	Phases []*Phase
+}
+
+// Track records the time elapsed since start as a phase
+func (t *PhaseTimer) Track(name string, start time.Time, bytes uint64) *Phase {
+	p := &Phase{Name: name, Elapsed: time.Since(start), Bytes: bytes}
+	t.Phases = append(t.Phases, p)
+	return p
+}
+
+// Throughput returns the processing rate of the phase in a human readable
+// form. Phases too short to be measured report no throughput rather than
+// dividing by zero.
+func (p *Phase) Throughput() string {
+	secs := p.Elapsed.Seconds()
+	if p.Bytes == 0 || secs <= 0 {
+		return "n/a"
+	}
+	return humanSize(uint64(float64(p.Bytes)/secs)) + "/s"
+}
+
+// Log the duration of every phase and the total
+func logSummary(t *PhaseTimer) {
+	total := time.Duration(0)
+	for _, p := range t.Phases {
+		total += p.Elapsed
+	}
+
+	slog.Info("Summary", "total", total.Round(time.Microsecond))
+	for _, p := range t.Phases {
+		if p.Bytes > 0 {
+			slog.Info("Phase", "name", p.Name, "took", p.Elapsed.Round(time.Microsecond), "size", humanSize(p.Bytes), "throughput", p.Throughput())
+		} else {
+			slog.Info("Phase", "name", p.Name, "took", p.Elapsed.Round(time.Microsecond))
+		}
+	}
+}
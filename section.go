@@ -1,14 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"slices"
 )
 
 var (
 	// size mismatch between bytes read: (%d) and n: (%d)
 	errReadSizeMismatch = "size mismatch between bytes read: (%d) and n: (%d)"
+
+	errMmapUnsupported = errors.New("memory mapping is not supported for this file")
 )
 
 type SectionData struct {
@@ -25,8 +32,122 @@ type SectionData struct {
 	FileSize    uint64
 	Ptrsz       int
 
+	// Compressed is set when the section contents are stored compressed in the
+	// file, in which case they can not be mapped directly.
+	Compressed bool
+
 	Order binary.ByteOrder
 	Data  io.ReadSeeker
+	// Bytes holds the section contents when the file is memory-mapped. Slices
+	// returned by ReadAt point into the mapping and must not be modified.
+	Bytes []byte
+
+	// parts are the sections combined by a view, see newSectionView
+	parts []*SectionData
+}
+
+// sectionView reads the address range covered by several sections, the gaps
+// between them read as zeroes
+type sectionView struct {
+	base  uint64
+	parts []*SectionData
+}
+
+func (v *sectionView) ReadAt(p []byte, off int64) (int, error) {
+	clear(p)
+	start := v.base + uint64(off)
+	end := start + uint64(len(p))
+
+	for _, s := range v.parts {
+		addr := s.VirtualAddr + s.BaseAddr
+		lo, hi := max(start, addr), min(end, addr+s.FileSize)
+		if lo >= hi {
+			continue
+		}
+		data, err := s.ReadAt(int64(lo-addr), hi-lo, io.SeekStart)
+		if err != nil {
+			return 0, err
+		}
+		copy(p[lo-start:], data)
+	}
+	return len(p), nil
+}
+
+// newSectionView combines sections into a single one spanning their address
+// range, so tables located in one section can point into another, such as a
+// table in PT_GNU_RELRO pointing at names in the read-only data
+func newSectionView(name string, parts []*SectionData) *SectionData {
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	parts = slices.Clone(parts)
+	slices.SortFunc(parts, func(a, b *SectionData) int {
+		return cmp.Compare(a.VirtualAddr+a.BaseAddr, b.VirtualAddr+b.BaseAddr)
+	})
+
+	start, end := parts[0].VirtualAddr+parts[0].BaseAddr, uint64(0)
+	for _, s := range parts {
+		end = max(end, s.VirtualAddr+s.BaseAddr+max(s.VirtualSize, s.FileSize))
+	}
+
+	return &SectionData{
+		Name: name,
+
+		VirtualAddr: start,
+		VirtualSize: end - start,
+		FileOffset:  parts[0].FileOffset,
+		FileSize:    end - start,
+
+		Order: parts[0].Order,
+		Ptrsz: parts[0].Ptrsz,
+		Data:  io.NewSectionReader(&sectionView{start, parts}, 0, int64(end-start)),
+		parts: parts,
+	}
+}
+
+// Parts returns the sections combined by a view, or the section itself. The
+// gaps between the parts of a view are not read when searching its contents.
+func (s *SectionData) Parts() []*SectionData {
+	if s.parts != nil {
+		return s.parts
+	}
+	return []*SectionData{s}
+}
+
+// ScanSize returns the number of bytes held by the parts of the section
+func (s *SectionData) ScanSize() uint64 {
+	size := uint64(0)
+	for _, p := range s.Parts() {
+		size += p.FileSize
+	}
+	return size
+}
+
+// Attach backs the section with a memory-mapped image of the whole file. The
+// section reader is replaced by a reader over the mapping so reads no longer
+// allocate. Returns false if the section can not be mapped, or its contents are
+// already held in memory, in which case the current reader is kept. The parts
+// of a view are attached instead of the view.
+func (s *SectionData) Attach(image []byte) bool {
+	if s.parts != nil {
+		attached := false
+		for _, p := range s.parts {
+			attached = p.Attach(image) || attached
+		}
+		return attached
+	}
+	if s.Compressed || s.Bytes != nil || s.FileOffset > uint64(len(image)) || s.FileSize > uint64(len(image))-s.FileOffset {
+		return false
+	}
+
+	s.Load(image[s.FileOffset : s.FileOffset+s.FileSize : s.FileOffset+s.FileSize])
+	return true
+}
+
+// Load backs the section with its contents held in memory
+func (s *SectionData) Load(contents []byte) {
+	s.Bytes = contents
+	s.Data = bytes.NewReader(s.Bytes)
 }
 
 // Returns the current cursor position of the section reader
@@ -61,6 +182,22 @@ func (s *SectionData) ReadptrFrom(b []byte) uint64 {
 // as the reference point (e.g., io.SeekStart.) The function also preserves the current section
 // cursor position.
 func (s *SectionData) ReadAt(offset int64, n uint64, whence int) ([]byte, error) {
+	if s.Bytes != nil {
+		return s.sliceAt(offset, n, whence)
+	}
+	if ra, ok := s.Data.(io.ReaderAt); ok && whence == io.SeekStart {
+		// avoid seeking back and forth when the reader supports positioned reads
+		buffer := make([]byte, n)
+		read, err := ra.ReadAt(buffer, offset)
+		if uint64(read) != n {
+			if err == nil || err == io.EOF {
+				err = fmt.Errorf(errReadSizeMismatch, read, n)
+			}
+			return nil, err
+		}
+		return buffer, nil
+	}
+
 	defer s.Data.Seek(s.Tell(), io.SeekStart)
 	s.Data.Seek(offset, whence)
 
@@ -77,6 +214,21 @@ func (s *SectionData) ReadAt(offset int64, n uint64, whence int) ([]byte, error)
 	return buffer, nil
 }
 
+// sliceAt returns n bytes of the memory-mapped section without copying
+func (s *SectionData) sliceAt(offset int64, n uint64, whence int) ([]byte, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.Tell()
+	case io.SeekEnd:
+		offset += int64(len(s.Bytes))
+	}
+
+	if offset < 0 || !s.ContainsRange(uint64(offset), n) {
+		return nil, fmt.Errorf(errReadSizeMismatch, 0, n)
+	}
+	return s.Bytes[offset : uint64(offset)+n], nil
+}
+
 // ContainsAddr checks if a virtual address (vaddr) exists within the address
 // boundaries of the current section.
 func (s *SectionData) ContainsAddr(vaddr uint64) bool {
@@ -84,6 +236,12 @@ func (s *SectionData) ContainsAddr(vaddr uint64) bool {
 	return vaddr >= base && vaddr <= base+s.VirtualSize
 }
 
+// ContainsRange checks if the n bytes starting at the section relative offset
+// exist within the section contents.
+func (s *SectionData) ContainsRange(offset, n uint64) bool {
+	return offset <= s.FileSize && n <= s.FileSize-offset
+}
+
 // Translate an absolute file offset to a virtual address
 func TL_VirtualAddress(s *SectionData, offset uint64) uint64 {
 	return offset - s.FileOffset + (s.VirtualAddr + s.BaseAddr)
@@ -91,6 +249,11 @@ func TL_VirtualAddress(s *SectionData, offset uint64) uint64 {
 
 // Translate a virtual address to an absolute file offset
 func TL_FileOffset(s *SectionData, vaddr uint64) uint64 {
+	for _, p := range s.parts {
+		if p.ContainsAddr(vaddr) {
+			return TL_FileOffset(p, vaddr)
+		}
+	}
 	return vaddr - (s.VirtualAddr + s.BaseAddr) + s.FileOffset
 }
 
@@ -99,10 +262,22 @@ func TL_SectionOffset(s *SectionData, vaddr uint64) uint64 {
 	return vaddr - (s.VirtualAddr + s.BaseAddr)
 }
 
-func PrintSectionInfo(s *SectionData) {
-	fmt.Printf("[~] Section info for \"%s\"\n", s.Name)
-	fmt.Printf("  - VA range: %#x-%#x\n", s.VirtualAddr+s.BaseAddr, s.VirtualAddr+s.VirtualSize+s.BaseAddr)
-	fmt.Printf("  - File offset: %#x\n", s.FileOffset)
-	fmt.Printf("  - File size: %d (%#[1]x)\n", s.FileSize)
-	fmt.Printf("  - PTR: %d\n", s.Ptrsz)
+// Output the location of the section, or of every part of a view
+func PrintSectionInfo(writer io.Writer, s *SectionData) {
+	for _, s := range s.Parts() {
+		fmt.Fprintf(writer, "Section: %s\n", s.Name)
+		fmt.Fprintf(writer, "  - VA range: %#x-%#x\n", s.VirtualAddr+s.BaseAddr, s.VirtualAddr+s.VirtualSize+s.BaseAddr)
+		fmt.Fprintf(writer, "  - File offset: %#x\n", s.FileOffset)
+		fmt.Fprintf(writer, "  - File size: %d (%#[1]x)\n", s.FileSize)
+		fmt.Fprintf(writer, "  - PTR: %d\n", s.Ptrsz)
+	}
+}
+
+// Log the location of the section, or of every part of a view, at debug level
+func logSectionInfo(s *SectionData) {
+	for _, s := range s.Parts() {
+		slog.Debug("Section info", "name", s.Name,
+			hexAttr("va", s.VirtualAddr+s.BaseAddr), hexAttr("va_end", s.VirtualAddr+s.VirtualSize+s.BaseAddr),
+			hexAttr("offset", s.FileOffset), "size", s.FileSize, "ptr", s.Ptrsz)
+	}
 }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// pclntab header magics of Go 1.2, 1.16, 1.18 and 1.20
+var pclntabMagics = []uint32{0xfffffffb, 0xfffffffa, 0xfffffff0, 0xfffffff1}
+
+// headerReader overlays a modified file header on the underlying reader
+type headerReader struct {
+	r   io.ReaderAt
+	hdr []byte
+}
+
+func (h *headerReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := h.r.ReadAt(p, off)
+	if off < int64(len(h.hdr)) {
+		copy(p, h.hdr[off:])
+	}
+	return n, err
+}
+
+// withoutSectionHeaders returns r with the section header table of its ELF
+// header cleared, so the file is described by its program headers only
+func withoutSectionHeaders(r io.ReaderAt, ident []byte) io.ReaderAt {
+	hdr := make([]byte, 64)
+	r.ReadAt(hdr, 0)
+
+	// offsets of e_shoff, e_shnum and e_shstrndx
+	shoff, shoffSize, shnum := 0x28, 8, 0x3c
+	if elf.Class(ident[elf.EI_CLASS]) == elf.ELFCLASS32 {
+		shoff, shoffSize, shnum = 0x20, 4, 0x30
+		hdr = hdr[:52]
+	}
+
+	clear(hdr[shoff : shoff+shoffSize])
+	clear(hdr[shnum : shnum+4])
+	return &headerReader{r, hdr}
+}
+
+// hasPclntab reports whether data holds the header of a Go pclntab, which
+// the linker places among the read-only data
+func hasPclntab(data []byte, order binary.ByteOrder) bool {
+	return findPclntab(data, order) != 0
+}
+
+// findPclntab returns the magic of the first pclntab header found in data, or
+// 0 if there is none
+func findPclntab(data []byte, order binary.ByteOrder) uint32 {
+	magic := make([]byte, 4)
+	for _, m := range pclntabMagics {
+		order.PutUint32(magic, m)
+
+		for b := data; ; {
+			i := bytes.Index(b, magic)
+			if i < 0 || i+8 > len(b) {
+				break
+			}
+			// two bytes of padding, the instruction size quantum and the pointer size
+			h := b[i : i+8]
+			if h[4] == 0 && h[5] == 0 && (h[6] == 1 || h[6] == 2 || h[6] == 4) && (h[7] == 4 || h[7] == 8) {
+				return m
+			}
+			b = b[i+1:]
+		}
+	}
+	return 0
+}
+
+// Sections falls back to the program headers when .rodata can not be found,
+// such as in binaries processed by sstrip or with renamed sections. Writable
+// segments are only scanned where they are covered by PT_GNU_RELRO. The
+// segments are scanned as a single view of their address range.
+func (x *exeELF) Sections() ([]*SectionData, error) {
+	sd, err := x.Rodata()
+	if err == nil {
+		// position independent executables keep their tables in .data.rel.ro,
+		// pointing at names and data in .rodata
+		if relro, rerr := x.SectionData(".data.rel.ro"); rerr == nil && relro.FileSize > 0 {
+			return []*SectionData{newSectionView(".rodata+.data.rel.ro", []*SectionData{sd, relro})}, nil
+		}
+		return []*SectionData{sd}, nil
+	}
+
+	psize, perr := elfPtrsz(x.f)
+	if perr != nil {
+		return nil, perr
+	}
+
+	// PT_GNU_RELRO ranges are made read-only once relocated
+	relro := []*elf.Prog{}
+	for _, p := range x.f.Progs {
+		if p.Type == elf.PT_GNU_RELRO {
+			relro = append(relro, p)
+		}
+	}
+
+	readonly, located := []*SectionData{}, []*SectionData{}
+	for _, p := range x.f.Progs {
+		if p.Type != elf.PT_LOAD || p.Filesz == 0 || p.Flags&elf.PF_R == 0 {
+			continue
+		}
+		if p.Flags&elf.PF_W != 0 {
+			readonly = append(readonly, relroSections(p, relro, x.f.ByteOrder, psize)...)
+			continue
+		}
+
+		s := &SectionData{
+			Name: fmt.Sprintf("PT_LOAD %#x", p.Vaddr),
+
+			VirtualAddr: p.Vaddr,
+			VirtualSize: p.Memsz,
+			FileOffset:  p.Off,
+			FileSize:    p.Filesz,
+
+			Order: x.f.ByteOrder,
+			Ptrsz: psize,
+			Data:  p.Open(),
+		}
+		if p.Flags&elf.PF_X == 0 {
+			readonly = append(readonly, s)
+			continue
+		}
+
+		// read-only data may share the segment of the code, such as with
+		// -Wl,--no-rosegment, it is recognized by the pclntab following it
+		if p.Filesz > uint64(MAX_FILE_SIZE) {
+			continue
+		}
+		data := make([]byte, p.Filesz)
+		if _, err := p.ReadAt(data, 0); err == nil && hasPclntab(data, x.f.ByteOrder) {
+			located = append(located, s)
+		}
+	}
+
+	// tables in PT_GNU_RELRO point at names and data in the read-only segments
+	sections := append(readonly, located...)
+	if len(sections) == 0 {
+		return nil, err
+	}
+	slog.Warn("No .rodata section, scanning read-only segments", "count", len(sections))
+	return []*SectionData{newSectionView("read-only segments", sections)}, nil
+}
+
+// relroSections returns the parts of the writable segment p, stored in the
+// file, covered by the relro segments
+func relroSections(p *elf.Prog, relro []*elf.Prog, order binary.ByteOrder, psize int) []*SectionData {
+	sections := []*SectionData{}
+	for _, r := range relro {
+		start, end := max(p.Vaddr, r.Vaddr), min(p.Vaddr+p.Filesz, r.Vaddr+r.Memsz)
+		if start >= end {
+			continue
+		}
+
+		sections = append(sections, &SectionData{
+			Name: fmt.Sprintf("PT_GNU_RELRO %#x", start),
+
+			VirtualAddr: start,
+			VirtualSize: end - start,
+			FileOffset:  p.Off + start - p.Vaddr,
+			FileSize:    end - start,
+
+			Order: order,
+			Ptrsz: psize,
+			Data:  io.NewSectionReader(p, int64(start-p.Vaddr), int64(end-start)),
+		})
+	}
+	return sections
+}
+
+const (
+	PE_SCN_CNT_INITIALIZED_DATA = 0x00000040
+	PE_SCN_MEM_DISCARDABLE      = 0x02000000
+	PE_SCN_MEM_EXECUTE          = 0x20000000
+	PE_SCN_MEM_WRITE            = 0x80000000
+)
+
+// Sections falls back to the read-only data sections, identified by their
+// characteristics, when .rdata was renamed
+func (x *exePE) Sections() ([]*SectionData, error) {
+	sd, err := x.Rodata()
+	if err == nil {
+		return []*SectionData{sd}, nil
+	}
+
+	readonly, located := []*SectionData{}, []*SectionData{}
+	for _, s := range x.f.Sections {
+		// discardable sections, such as DWARF, are not loaded
+		if s.Size == 0 || s.Characteristics&(PE_SCN_MEM_WRITE|PE_SCN_MEM_DISCARDABLE) != 0 {
+			continue
+		}
+		if s.Characteristics&PE_SCN_CNT_INITIALIZED_DATA != 0 && s.Characteristics&PE_SCN_MEM_EXECUTE == 0 {
+			readonly = append(readonly, x.sectionData(s))
+			continue
+		}
+
+		// sections with forged characteristics are recognized by the pclntab
+		if data, err := s.Data(); err == nil && hasPclntab(data, binary.LittleEndian) {
+			located = append(located, x.sectionData(s))
+		}
+	}
+
+	sections := append(readonly, located...)
+	if len(sections) == 0 {
+		return nil, err
+	}
+	slog.Warn("No .rdata section, scanning read-only sections", "count", len(sections))
+	return sections, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+)
+
+// relroELF returns a PIE without section headers whose embed table is in the
+// PT_GNU_RELRO part of the data segment, while the names and contents of its
+// files are in the read-only segment
+func relroELF() []byte {
+	const (
+		rodataOff, rodataAddr = 0x1000, 0x1000
+		relroOff, relroAddr   = 0x2000, 0x3000
+		segSize               = 0x100
+	)
+	le := binary.LittleEndian
+
+	img := make([]byte, relroOff+segSize)
+	progs := []elf.Prog64{
+		{Type: uint32(elf.PT_LOAD), Flags: uint32(elf.PF_R), Off: rodataOff, Vaddr: rodataAddr, Filesz: segSize, Memsz: segSize, Align: 0x1000},
+		{Type: uint32(elf.PT_LOAD), Flags: uint32(elf.PF_R | elf.PF_W), Off: relroOff, Vaddr: relroAddr, Filesz: segSize, Memsz: segSize, Align: 0x1000},
+		{Type: uint32(elf.PT_GNU_RELRO), Flags: uint32(elf.PF_R), Off: relroOff, Vaddr: relroAddr, Filesz: segSize, Memsz: segSize, Align: 1},
+	}
+	hdr := elf.Header64{
+		Type: uint16(elf.ET_DYN), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Phoff: 64, Ehsize: 64, Phentsize: 56, Phnum: uint16(len(progs)),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS], hdr.Ident[elf.EI_DATA], hdr.Ident[elf.EI_VERSION] = byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)
+
+	var b bytes.Buffer
+	binary.Write(&b, le, hdr)
+	binary.Write(&b, le, progs)
+	copy(img, b.Bytes())
+
+	// names and contents in the read-only segment
+	putEmbedFS(img, le, relroOff, relroAddr, rodataOff, rodataAddr, embedFiles)
+	return img
+}
+
+// embedFile is a file of the tables written by putEmbedFS
+type embedFile struct{ name, data string }
+
+var embedFiles = []embedFile{{"a.txt", "hello"}, {"b.txt", "world"}}
+
+// putEmbedFS writes an embed table of files at tableOff in img, located at
+// tableAddr, with the names and contents at dataOff, located at dataAddr.
+// File i has its name at dataOff+i*0x20 and its contents 0x10 bytes further.
+func putEmbedFS(img []byte, order binary.AppendByteOrder, tableOff, tableAddr, dataOff, dataAddr uint64, files []embedFile) {
+	table := order.AppendUint64(nil, tableAddr+24)
+	table = order.AppendUint64(table, uint64(len(files)))
+	table = order.AppendUint64(table, uint64(len(files)))
+	for i, f := range files {
+		name := uint64(i * 0x20)
+		copy(img[dataOff+name:], f.name)
+		copy(img[dataOff+name+0x10:], f.data)
+
+		sum := sha256.Sum256([]byte(f.data))
+		table = order.AppendUint64(table, dataAddr+name)
+		table = order.AppendUint64(table, uint64(len(f.name)))
+		table = order.AppendUint64(table, dataAddr+name+0x10)
+		table = order.AppendUint64(table, uint64(len(f.data)))
+		table = append(table, sum[:16]...)
+	}
+	copy(img[tableOff:], table)
+}
+
+func TestRelroTable(t *testing.T) {
+	img := relroELF()
+
+	for _, mapped := range []bool{false, true} {
+		x, err := DetectExeFormat(bytes.NewReader(img))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sections, err := x.Sections()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sections) != 1 {
+			t.Fatalf("got %d sections, want a single view", len(sections))
+		}
+		if mapped && !sections[0].Attach(img) {
+			t.Fatal("view not attached")
+		}
+
+		candidates := findCandidates(sections[0], DefaultScanOptions())
+		if len(candidates) != 1 {
+			t.Fatalf("mapped %v: got %d candidates, want 1", mapped, len(candidates))
+		}
+		c := candidates[0]
+		if c.Addr != 0x3018 || TL_FileOffset(c.sd, c.Addr) != 0x2018 {
+			t.Errorf("candidate at %#x (file %#x), want 0x3018 (file 0x2018)", c.Addr, TL_FileOffset(c.sd, c.Addr))
+		}
+
+		for i, f := range embedFiles {
+			e := c.Entry(uint64(i))
+			data, err := e.Read()
+			if err != nil || e.Name != f.name || string(data) != f.data || !e.VerifyHash(data) {
+				t.Errorf("entry %s: read %q (%v), want %s %q", e.Name, data, err, f.name, f.data)
+			}
+			if e.FileOffset() != uint64(0x1010+i*0x20) {
+				t.Errorf("entry %s: file offset %#x", e.Name, e.FileOffset())
+			}
+		}
+	}
+}
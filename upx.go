@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var (
+	// UPX packed executable not supported: %s
+	errUPXUnsupported = "UPX packed executable not supported: %s"
+	// malformed UPX block at %#x
+	errUPXBlock = "malformed UPX block at %#x"
+	// UPX block decompressed to %d bytes, expected %d
+	errUPXBlockSize = "UPX block decompressed to %d bytes, expected %d"
+	// UPX blocks do not match the original program headers
+	errUPXLayout = "UPX blocks do not match the original program headers"
+)
+
+const (
+	UPX_MAGIC = "UPX!"
+
+	// compression methods, reference: upx/src/conf.h
+	UPX_M_NRV2B_LE32 = 2
+	UPX_M_NRV2B_8    = 3
+	UPX_M_NRV2B_LE16 = 4
+	UPX_M_NRV2D_LE32 = 5
+	UPX_M_NRV2D_8    = 6
+	UPX_M_NRV2D_LE16 = 7
+	UPX_M_NRV2E_LE32 = 8
+	UPX_M_NRV2E_8    = 9
+	UPX_M_NRV2E_LE16 = 10
+	UPX_M_LZMA       = 14
+
+	// sizes of l_info, p_info and b_info
+	UPX_LINFO_SIZE = 12
+	UPX_PINFO_SIZE = 12
+	UPX_BINFO_SIZE = 12
+
+	// packed data is located in the first pages of packed ELF executables
+	UPX_HEADER_SEARCH = 0x1000
+
+	// PackHeader formats of PE executables, reference: upx/src/conf.h
+	UPX_F_W32PE_I386  = 9
+	UPX_F_WINCE_ARM   = 21
+	UPX_F_W64PE_AMD64 = 36
+	// the layout of the PackHeader parsed here is used since version 10
+	UPX_MIN_PH_VERSION = 10
+)
+
+// filters applied by the PE packers, they only rewrite the branches of the code
+// section so the data is left intact
+// reference: upx/src/p_w32pe_i386.cpp, p_w64pe_amd64.cpp, p_wince_arm.cpp (getFilters)
+var upxPEFilters = map[uint8]bool{
+	0x00: true,
+	0x11: true, 0x12: true, 0x13: true, 0x14: true, 0x15: true, 0x16: true,
+	0x24: true, 0x25: true, 0x26: true, 0x46: true, 0x49: true,
+	0x50: true, 0x51: true,
+}
+
+// upxPackHeader is the PackHeader written by UPX after its version string
+// reference: upx/src/packhead.cpp
+type upxPackHeader struct {
+	Version uint8
+	Format  uint8
+	Method  uint8
+	Level   uint8
+
+	ULen      uint32 // uncompressed length
+	CLen      uint32 // compressed length
+	UFileSize uint32
+
+	Filter uint8
+}
+
+// findPackHeader returns the last PackHeader of data. Formats from 128 on are
+// those of big-endian targets.
+func findPackHeader(data []byte) *upxPackHeader {
+	for i := bytes.LastIndex(data, []byte(UPX_MAGIC)); i >= 0; i = bytes.LastIndex(data[:i], []byte(UPX_MAGIC)) {
+		h := data[i:]
+		if len(h) < 29 || h[4] == 0 || h[5] == 0 || h[6] == 0 {
+			continue
+		}
+
+		var order binary.ByteOrder = binary.LittleEndian
+		if h[5] >= 128 {
+			order = binary.BigEndian
+		}
+		return &upxPackHeader{
+			Version: h[4],
+			Format:  h[5],
+			Method:  h[6],
+			Level:   h[7],
+
+			ULen:      order.Uint32(h[16:20]),
+			CLen:      order.Uint32(h[20:24]),
+			UFileSize: order.Uint32(h[24:28]),
+
+			Filter: h[28],
+		}
+	}
+	return nil
+}
+
+// upxDecompress decompresses a block of UPX compressed with method to size
+// bytes
+func upxDecompress(method uint8, src []byte, size uint32) ([]byte, error) {
+	dst := make([]byte, size)
+
+	var variant string
+	var width uint
+	switch method {
+	case UPX_M_NRV2B_LE32, UPX_M_NRV2B_8, UPX_M_NRV2B_LE16:
+		variant, width = "2b", []uint{32, 8, 16}[method-UPX_M_NRV2B_LE32]
+	case UPX_M_NRV2D_LE32, UPX_M_NRV2D_8, UPX_M_NRV2D_LE16:
+		variant, width = "2d", []uint{32, 8, 16}[method-UPX_M_NRV2D_LE32]
+	case UPX_M_NRV2E_LE32, UPX_M_NRV2E_8, UPX_M_NRV2E_LE16:
+		variant, width = "2e", []uint{32, 8, 16}[method-UPX_M_NRV2E_LE32]
+	case UPX_M_LZMA:
+		return upxLZMA(src, dst)
+	default:
+		return nil, fmt.Errorf(errUPXUnsupported, fmt.Sprintf("compression method %d", method))
+	}
+
+	n, err := nrvDecompress(variant, width, src, dst)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(dst) {
+		return nil, fmt.Errorf(errUPXBlockSize, n, len(dst))
+	}
+	return dst, nil
+}
+
+// upxLZMA decompresses a raw LZMA stream preceded by the two bytes UPX encodes
+// its properties in, by prefixing it with the header of the classic format
+func upxLZMA(src, dst []byte) ([]byte, error) {
+	if len(src) < 2 {
+		return nil, fmt.Errorf(errUPXBlock, 0)
+	}
+	pb, lp, lc := src[0]&7, src[1]>>4, src[1]&15
+	if pb > 4 || lp > 4 || lc > 8 {
+		return nil, fmt.Errorf(errUPXUnsupported, "LZMA properties")
+	}
+
+	// properties, dictionary size and uncompressed size
+	hdr := make([]byte, 13)
+	hdr[0] = (pb*5+lp)*9 + lc
+	binary.LittleEndian.PutUint32(hdr[1:5], uint32(max(len(dst), lzma.MinDictCap)))
+	binary.LittleEndian.PutUint64(hdr[5:13], uint64(len(dst)))
+
+	r, err := lzma.NewReader(io.MultiReader(bytes.NewReader(hdr), bytes.NewReader(src[2:])))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, dst); err != nil {
+		return nil, fmt.Errorf(errUPXBlockSize, 0, len(dst))
+	}
+	return dst, nil
+}
+
+// exeUPX is the unpacked image of a UPX packed executable
+type exeUPX struct {
+	exe
+}
+
+func (x *exeUPX) FormatName() string { return x.exe.FormatName() + " (UPX unpacked)" }
+
+// unpackUPX returns the unpacked image of x read from r, or nil when x is not
+// packed by UPX. Filters of the code, such as the call trick of x86, are not
+// reverted, as only the data is scanned.
+func unpackUPX(x exe, r io.ReaderAt) (exe, []byte, error) {
+	var data []byte
+	var err error
+	var ux exe
+
+	switch x := x.(type) {
+	case *exeELF:
+		data, err = unpackUPXELF(x.f, r)
+		if data != nil {
+			ux, err = DetectExeFormat(bytes.NewReader(data))
+		}
+	case *exePE:
+		ux, data, err = unpackUPXPE(x.f, r)
+	default:
+		return nil, nil, nil
+	}
+
+	if err != nil || ux == nil {
+		return nil, nil, err
+	}
+	slog.Info("Unpacked UPX executable", "size", len(data))
+	return &exeUPX{ux}, data, nil
+}
+
+// upxBlocks reads the sequence of b_info headers and compressed blocks of a
+// packed ELF executable
+type upxBlocks struct {
+	r      io.ReaderAt
+	offset int64
+	order  binary.ByteOrder
+}
+
+// next returns the next decompressed block, or io.EOF at the end marker
+func (b *upxBlocks) next() ([]byte, error) {
+	hdr := make([]byte, UPX_BINFO_SIZE)
+	if _, err := b.r.ReadAt(hdr, b.offset); err != nil {
+		return nil, fmt.Errorf(errUPXBlock, b.offset)
+	}
+	unc, cpr, method, ftid := b.order.Uint32(hdr[0:4]), b.order.Uint32(hdr[4:8]), hdr[8], hdr[9]
+	if unc == 0 {
+		return nil, io.EOF
+	}
+	if cpr > unc || int64(unc) > MAX_FILE_SIZE {
+		return nil, fmt.Errorf(errUPXBlock, b.offset)
+	}
+
+	src := make([]byte, cpr)
+	if _, err := b.r.ReadAt(src, b.offset+UPX_BINFO_SIZE); err != nil {
+		return nil, fmt.Errorf(errUPXBlock, b.offset)
+	}
+	at := b.offset
+	b.offset += UPX_BINFO_SIZE + int64(cpr)
+
+	// incompressible blocks are stored
+	if cpr == unc {
+		return src, nil
+	}
+	if ftid != 0 {
+		slog.Debug("Not reverting UPX filter", "filter", ftid, "offset", at)
+	}
+	data, err := upxDecompress(method, src, unc)
+	if err != nil {
+		return nil, fmt.Errorf("%w (block at %#x)", err, at)
+	}
+	return data, nil
+}
+
+// unpackUPXELF rebuilds the original file of a packed ELF executable. Its
+// l_info and p_info headers follow the program headers, then come the blocks
+// of the original ELF and program headers, the contents of every PT_LOAD, and
+// the gaps between them. Returns nil when f is not packed.
+// reference: upx/src/p_lx_elf.cpp, PackLinuxElf64::unpack
+func unpackUPXELF(f *elf.File, r io.ReaderAt) ([]byte, error) {
+	// packed executables keep no section headers
+	if f.Section(".rodata") != nil {
+		return nil, nil
+	}
+
+	head := make([]byte, UPX_HEADER_SEARCH)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	// l_info: l_checksum, l_magic, l_lsize, l_version, l_format
+	i := bytes.Index(head, []byte(UPX_MAGIC))
+	if i < 4 {
+		return nil, nil
+	}
+	linfo := int64(i - 4)
+
+	order := f.ByteOrder
+	pinfo := make([]byte, UPX_PINFO_SIZE)
+	if _, err := r.ReadAt(pinfo, linfo+UPX_LINFO_SIZE); err != nil {
+		return nil, nil
+	}
+	// p_info: p_progid, p_filesize, p_blocksize
+	size, blocksize := order.Uint32(pinfo[4:8]), order.Uint32(pinfo[8:12])
+	if size == 0 || blocksize == 0 || int64(size) > MAX_FILE_SIZE {
+		return nil, fmt.Errorf(errUPXUnsupported, "malformed p_info header")
+	}
+	slog.Debug("Found UPX headers", "offset", linfo, "size", size)
+
+	blocks := &upxBlocks{r, linfo + UPX_LINFO_SIZE + UPX_PINFO_SIZE, order}
+	hdr, err := blocks.next()
+	if err != nil {
+		return nil, err
+	}
+	if uint32(len(hdr)) > size {
+		return nil, fmt.Errorf(errUPXLayout)
+	}
+
+	orig, err := elf.NewFile(withoutSectionHeaders(bytes.NewReader(hdr), hdr))
+	if err != nil {
+		return nil, fmt.Errorf(errUPXUnsupported, err)
+	}
+
+	out := make([]byte, size)
+	copy(out, hdr)
+
+	// fill places the following blocks in out[start:end]
+	fill := func(start, end uint64) error {
+		for start < end {
+			b, err := blocks.next()
+			if err != nil {
+				return err
+			}
+			if uint64(len(b)) > end-start {
+				return fmt.Errorf(errUPXLayout)
+			}
+			copy(out[start:], b)
+			start += uint64(len(b))
+		}
+		return nil
+	}
+
+	loads := []*elf.Prog{}
+	for _, p := range orig.Progs {
+		if p.Type != elf.PT_LOAD {
+			continue
+		}
+		if p.Off+p.Filesz > uint64(size) || p.Off+p.Filesz < p.Off {
+			return nil, fmt.Errorf(errUPXLayout)
+		}
+		loads = append(loads, p)
+	}
+
+	// the first segment holds the headers, which were packed separately
+	covered := uint64(len(hdr))
+	for _, p := range loads {
+		start := p.Off
+		if start < covered {
+			start = min(covered, p.Off+p.Filesz)
+		}
+		if err := fill(start, p.Off+p.Filesz); err != nil {
+			return nil, err
+		}
+		covered = max(covered, p.Off+p.Filesz)
+	}
+
+	// the gaps hold data outside of the segments, such as the section headers
+	for k, p := range loads {
+		end := uint64(size)
+		if k+1 < len(loads) {
+			end = loads[k+1].Off
+		}
+		if start := p.Off + p.Filesz; start < end {
+			if err := fill(start, end); err != nil {
+				if err != io.EOF {
+					slog.Debug("Ignoring UPX gap", "offset", start, "err", err)
+				}
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// unpackUPXPE decompresses the image of a packed PE executable. UPX0 reserves
+// the memory of the original sections and UPX1 starts with their contents,
+// compressed as a single block described by the PackHeader preceding UPX1.
+// Formats and filters which could leave the data of the image altered are
+// reported as unsupported. Returns nil when f is not packed.
+// reference: upx/src/pefile.cpp
+func unpackUPXPE(f *pe.File, r io.ReaderAt) (exe, []byte, error) {
+	if len(f.Sections) < 2 {
+		return nil, nil, nil
+	}
+	upx0, upx1 := f.Sections[0], f.Sections[1]
+	named := upx0.Name == "UPX0" && upx1.Name == "UPX1"
+	if !named && (upx0.Size != 0 || upx0.VirtualSize == 0) {
+		return nil, nil, nil
+	}
+
+	head := make([]byte, min(upx1.Offset, UPX_HEADER_SEARCH))
+	if _, err := r.ReadAt(head, int64(upx1.Offset)-int64(len(head))); err != nil {
+		return nil, nil, nil
+	}
+	ph := findPackHeader(head)
+	if ph == nil {
+		if named {
+			return nil, nil, fmt.Errorf(errUPXUnsupported, "missing PackHeader, the file was likely packed by a modified UPX")
+		}
+		return nil, nil, nil
+	}
+	slog.Debug("Found UPX PackHeader", "version", ph.Version, "format", ph.Format, "method", ph.Method, "filter", ph.Filter)
+
+	switch {
+	case ph.Version < UPX_MIN_PH_VERSION:
+		return nil, nil, fmt.Errorf(errUPXUnsupported, fmt.Sprintf("PackHeader version %d", ph.Version))
+	case ph.Format != UPX_F_W32PE_I386 && ph.Format != UPX_F_WINCE_ARM && ph.Format != UPX_F_W64PE_AMD64:
+		return nil, nil, fmt.Errorf(errUPXUnsupported, fmt.Sprintf("PackHeader format %d", ph.Format))
+	case !upxPEFilters[ph.Filter]:
+		return nil, nil, fmt.Errorf(errUPXUnsupported, fmt.Sprintf("filter %#x", ph.Filter))
+	}
+	// the image is decompressed in place, over UPX0 and UPX1
+	if ph.CLen > upx1.Size || int64(ph.ULen) > MAX_FILE_SIZE || ph.ULen > upx0.VirtualSize+upx1.VirtualSize {
+		return nil, nil, fmt.Errorf(errUPXUnsupported, "malformed PackHeader")
+	}
+	src := make([]byte, ph.CLen)
+	if _, err := r.ReadAt(src, int64(upx1.Offset)); err != nil {
+		return nil, nil, err
+	}
+	data, err := upxDecompress(ph.Method, src, ph.ULen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the image starts with the original sections, at the address of UPX0
+	opt := &RawOptions{Order: "little"}
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		opt.Base, opt.Ptrsz = uint64(oh.ImageBase)+uint64(upx0.VirtualAddress), 4
+	case *pe.OptionalHeader64:
+		opt.Base, opt.Ptrsz = oh.ImageBase+uint64(upx0.VirtualAddress), 8
+	default:
+		return nil, nil, fmt.Errorf(errUPXUnsupported, "missing optional header")
+	}
+
+	x, err := NewRawExe(bytes.NewReader(data), int64(len(data)), opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &exePEImage{x}, data, nil
+}
+
+// exePEImage is the memory image of the sections of a PE executable
+type exePEImage struct {
+	exe
+}
+
+func (x *exePEImage) FormatName() string { return "PE image" }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// testdata/upx-small.upx.elf is testdata/upx-small.elf packed with NRV2B_LE32
+func TestUnpackUPXELF(t *testing.T) {
+	orig, err := os.ReadFile("testdata/upx-small.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	packed, err := os.ReadFile("testdata/upx-small.upx.elf")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, err := DetectExeFormat(bytes.NewReader(packed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ux, data, err := unpackUPX(x, bytes.NewReader(packed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ux == nil {
+		t.Fatal("packed executable not detected")
+	}
+	if !bytes.Equal(data, orig) {
+		t.Errorf("unpacked %d bytes, want the %d bytes of the original", len(data), len(orig))
+	}
+	if _, ok := ux.(*exeUPX).exe.(*exeELF); !ok {
+		t.Errorf("unpacked format %s, want ELF", ux.FormatName())
+	}
+
+	// the original is not packed
+	x, err = DetectExeFormat(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ux, _, err := unpackUPX(x, bytes.NewReader(orig)); ux != nil || err != nil {
+		t.Errorf("unpacked executable not packed: %v", err)
+	}
+}
+
+func TestUnpackUPXELFLZMA(t *testing.T) {
+	orig := relroELF()
+	packed := packUPXELF(t, orig, upxLZMACompress)
+
+	x, err := DetectExeFormat(bytes.NewReader(packed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ux, data, err := unpackUPX(x, bytes.NewReader(packed))
+	if err != nil || ux == nil {
+		t.Fatalf("not unpacked: %v", err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Errorf("unpacked %d bytes, want the %d bytes of the original", len(data), len(orig))
+	}
+}
+
+// upxPEImage returns the memory image of a Go PE executable, holding an embed
+// table and the build information
+func upxPEImage() []byte {
+	const base = UPX_PE_IMAGE_BASE + UPX_PE_UPX0
+	image := make([]byte, 0x200)
+	putEmbedFS(image, binary.LittleEndian, 0x100, base+0x100, 0, base, embedFiles)
+	copy(image[0x180:], buildInfo("go1.22.3"))
+	return image
+}
+
+// upxNRV2B returns the NRV2B_LE32 stream of nrvPlain whatever the data
+func upxNRV2B(t *testing.T, data []byte) ([]byte, uint8) {
+	src, _ := hex.DecodeString(nrvVectors[2].src)
+	return src, nrvVectors[2].method
+}
+
+func TestUnpackUPXPE(t *testing.T) {
+	tests := []struct {
+		name     string
+		pe       upxPE
+		compress upxCompress
+	}{
+		{"lzma", upxPE{image: upxPEImage(), version: 13, format: UPX_F_W64PE_AMD64}, upxLZMACompress},
+		{"lzma filtered", upxPE{image: upxPEImage(), version: 14, format: UPX_F_W64PE_AMD64, filter: 0x49}, upxLZMACompress},
+		{"nrv2b", upxPE{image: nrvPlain, version: 13, format: UPX_F_W32PE_I386}, upxNRV2B},
+	}
+
+	for _, tt := range tests {
+		packed := packUPXPE(t, tt.pe, tt.compress)
+		x, err := DetectExeFormat(bytes.NewReader(packed))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		ux, data, err := unpackUPX(x, bytes.NewReader(packed))
+		if err != nil || ux == nil {
+			t.Fatalf("%s: not unpacked: %v", tt.name, err)
+		}
+		if !bytes.Equal(data, tt.pe.image) {
+			t.Errorf("%s: unpacked %d bytes, want the %d bytes of the image", tt.name, len(data), len(tt.pe.image))
+		}
+	}
+
+	// the image is scanned at the address of UPX0
+	target, err := newMemoryTarget(packUPXPE(t, tests[0].pe, upxLZMACompress), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := target.Identify(); id.Version != "go1.22.3" {
+		t.Errorf("identified %s", id)
+	}
+	if candidates := findCandidates(target.Sections[0], DefaultScanOptions()); len(candidates) != 1 || candidates[0].EntryCount != 2 {
+		t.Errorf("found %d candidates, want 1 of 2 files", len(candidates))
+	}
+}
+
+func TestUnpackUPXPEUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		pe   upxPE
+	}{
+		{"old PackHeader", upxPE{image: upxPEImage(), version: 9, format: UPX_F_W64PE_AMD64}},
+		{"ELF format", upxPE{image: upxPEImage(), version: 13, format: 22}},
+		{"unknown filter", upxPE{image: upxPEImage(), version: 13, format: UPX_F_W64PE_AMD64, filter: 0xd0}},
+	}
+
+	for _, tt := range tests {
+		packed := packUPXPE(t, tt.pe, upxLZMACompress)
+		x, err := DetectExeFormat(bytes.NewReader(packed))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, _, err := unpackUPX(x, bytes.NewReader(packed)); err == nil || !strings.Contains(err.Error(), "not supported") {
+			t.Errorf("%s: got %v, want unsupported", tt.name, err)
+		}
+	}
+}
+
+// upxCompress compresses blocks of the packed executables built by the tests,
+// it returns the compressed block and the method
+type upxCompress func(t *testing.T, data []byte) ([]byte, uint8)
+
+// upxStore stores blocks without compression
+func upxStore(t *testing.T, data []byte) ([]byte, uint8) {
+	return data, UPX_M_NRV2B_LE32
+}
+
+// upxLZMACompress compresses blocks as the raw LZMA streams of UPX, preceded
+// by the two bytes encoding their properties
+func upxLZMACompress(t *testing.T, data []byte) ([]byte, uint8) {
+	props := lzma.Properties{LC: 3, LP: 0, PB: 2}
+
+	var b bytes.Buffer
+	w, err := lzma.WriterConfig{Properties: &props, Size: int64(len(data))}.NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hdr := []byte{byte((props.LC+props.LP)<<3 | props.PB), byte(props.LP<<4 | props.LC)}
+	return append(hdr, b.Bytes()[13:]...), UPX_M_LZMA
+}
+
+// packUPXELF packs the ELF executable orig the way UPX does: l_info and p_info
+// follow the program headers, then come the blocks of the ELF and program
+// headers and of every PT_LOAD
+func packUPXELF(t *testing.T, orig []byte, compress upxCompress) []byte {
+	f, err := elf.NewFile(bytes.NewReader(orig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+
+	// a single PT_LOAD covers the packed file
+	hdr := elf.Header64{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_X86_64), Version: uint32(elf.EV_CURRENT),
+		Phoff: 64, Ehsize: 64, Phentsize: 56, Phnum: 1,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS], hdr.Ident[elf.EI_DATA], hdr.Ident[elf.EI_VERSION] = byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)
+
+	var b bytes.Buffer
+	binary.Write(&b, le, hdr)
+	binary.Write(&b, le, elf.Prog64{Type: uint32(elf.PT_LOAD), Flags: uint32(elf.PF_R | elf.PF_X), Vaddr: 0x400000, Align: 0x1000})
+	b.Write([]byte{0, 0, 0, 0})
+	b.WriteString(UPX_MAGIC)
+	b.Write([]byte{0, 0, 13, 22})
+	b.Write(le.AppendUint32(le.AppendUint32([]byte{0, 0, 0, 0}, uint32(len(orig))), uint32(len(orig))))
+
+	block := func(data []byte) {
+		src, method := compress(t, data)
+		b.Write(le.AppendUint32(le.AppendUint32(nil, uint32(len(data))), uint32(len(src))))
+		b.Write([]byte{method, 0, 0, 0})
+		b.Write(src)
+	}
+	block(orig[:64+len(f.Progs)*56])
+	for _, p := range f.Progs {
+		if p.Type == elf.PT_LOAD {
+			block(orig[p.Off : p.Off+p.Filesz])
+		}
+	}
+	b.Write(make([]byte, UPX_BINFO_SIZE))
+
+	packed := b.Bytes()
+	le.PutUint64(packed[64+32:], uint64(len(packed)))
+	le.PutUint64(packed[64+40:], uint64(len(packed)))
+	return packed
+}
+
+// upxPE describes a packed PE executable built by packUPXPE
+type upxPE struct {
+	image   []byte // memory image of the original sections
+	version uint8  // PackHeader fields
+	format  uint8
+	filter  uint8
+}
+
+const (
+	UPX_PE_IMAGE_BASE = 0x140000000
+	UPX_PE_UPX0       = 0x1000
+)
+
+// packUPXPE packs the memory image of the original sections the way UPX does:
+// UPX0 reserves their memory and UPX1 starts with the compressed image, which
+// is described by the PackHeader preceding UPX1
+func packUPXPE(t *testing.T, p upxPE, compress upxCompress) []byte {
+	const fileAlign, sectAlign, upx1Off = 0x200, 0x1000, 0x400
+	le := binary.LittleEndian
+	align := func(n, a int) uint32 { return uint32((n + a - 1) &^ (a - 1)) }
+
+	src, method := compress(t, p.image)
+	vsize0 := align(len(p.image), sectAlign)
+	sections := []pe.SectionHeader32{
+		{Name: [8]uint8{'U', 'P', 'X', '0'}, VirtualSize: vsize0, VirtualAddress: UPX_PE_UPX0, Characteristics: 0xe0000080},
+		{Name: [8]uint8{'U', 'P', 'X', '1'}, VirtualSize: align(len(src), sectAlign), VirtualAddress: UPX_PE_UPX0 + vsize0,
+			SizeOfRawData: align(len(src), fileAlign), PointerToRawData: upx1Off, Characteristics: 0xe0000040},
+	}
+	oh := pe.OptionalHeader64{
+		Magic: 0x20b, ImageBase: UPX_PE_IMAGE_BASE, SectionAlignment: sectAlign, FileAlignment: fileAlign,
+		MajorSubsystemVersion: 6, SizeOfImage: UPX_PE_UPX0 + vsize0 + sections[1].VirtualSize,
+		SizeOfHeaders: upx1Off, Subsystem: 3, NumberOfRvaAndSizes: 16,
+	}
+	fh := pe.FileHeader{Machine: pe.IMAGE_FILE_MACHINE_AMD64, NumberOfSections: 2, SizeOfOptionalHeader: 240, Characteristics: 0x22}
+
+	var b bytes.Buffer
+	b.WriteString("MZ")
+	b.Write(make([]byte, 0x3a))
+	b.Write(le.AppendUint32(nil, 0x40))
+	b.WriteString("PE\x00\x00")
+	binary.Write(&b, le, fh)
+	binary.Write(&b, le, oh)
+	binary.Write(&b, le, sections)
+
+	// version, format, method, level, then u_adler and c_adler, u_len, c_len,
+	// u_file_size, filter, filter_cto, n_mru and the checksum
+	ph := []byte(UPX_MAGIC)
+	ph = append(ph, p.version, p.format, method, 8)
+	ph = append(ph, make([]byte, 8)...)
+	ph = le.AppendUint32(ph, uint32(len(p.image)))
+	ph = le.AppendUint32(ph, uint32(len(src)))
+	ph = le.AppendUint32(ph, uint32(len(p.image)))
+	ph = append(ph, p.filter, 0, 0, 0)
+
+	packed := make([]byte, upx1Off+int(sections[1].SizeOfRawData))
+	copy(packed, b.Bytes())
+	copy(packed[upx1Off-len(ph):], ph)
+	copy(packed[upx1Off:], src)
+	return packed
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"sort"
+)
+
+var (
+	// malformed wasm module: %s
+	errWasm = "malformed wasm module: %s"
+	// wasm module has no data segments
+	errWasmData = "wasm module has no data segments"
+)
+
+const (
+	WASM_MAGIC = "\x00asm"
+
+	// reference: https://webassembly.github.io/spec/core/binary/modules.html
+	WASM_SECTION_CUSTOM = 0
+	WASM_SECTION_DATA   = 11
+
+	WASM_OP_I32_CONST = 0x41
+	WASM_OP_I64_CONST = 0x42
+	WASM_OP_END       = 0x0b
+
+	// segments further apart than a page of linear memory start a new section
+	WASM_PAGE_SIZE = 64 * 1024
+)
+
+// WasmSegment is an active data segment, copied to offset Addr of the linear
+// memory when the module is instantiated
+type WasmSegment struct {
+	Addr   uint64
+	Offset int64 // file offset of the contents
+	Size   uint64
+}
+
+// exeWasm is a WebAssembly module. Modules have no addressable sections, the
+// data segments initializing the linear memory are scanned instead.
+type exeWasm struct {
+	r     io.ReaderAt
+	ptrsz int
+
+	Segments []*WasmSegment
+}
+
+// wasmReader reads a module sequentially, keeping track of the file offset
+type wasmReader struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (w *wasmReader) ReadByte() (byte, error) {
+	b, err := w.r.ReadByte()
+	if err == nil {
+		w.offset++
+	}
+	return b, err
+}
+
+func (w *wasmReader) Read(p []byte) (int, error) {
+	n, err := w.r.Read(p)
+	w.offset += int64(n)
+	return n, err
+}
+
+// NewWasm parses the sections of the wasm module r
+func NewWasm(r io.ReaderAt) (*exeWasm, error) {
+	wr := &wasmReader{r: bufio.NewReader(io.NewSectionReader(r, 0, math.MaxInt64))}
+
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(wr, hdr); err != nil || string(hdr[:4]) != WASM_MAGIC {
+		return nil, fmt.Errorf(errUnrecognizedFormat)
+	}
+
+	// the linear memory of wasm32 is addressed with 32-bit pointers, the Go
+	// toolchain still stores pointers in 8 bytes
+	x := &exeWasm{r: r, ptrsz: 4}
+	size := readerSize(r)
+
+	for {
+		id, err := wr.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf(errWasm, "section header")
+		}
+		// sections can not extend past the end of the module
+		n, err := readUleb128(wr)
+		if err != nil || n > uint64(MAX_FILE_SIZE) || int64(n) > size-wr.offset {
+			return nil, fmt.Errorf(errWasm, "section header")
+		}
+
+		offset := wr.offset
+		contents := make([]byte, n)
+		if _, err := io.ReadFull(wr, contents); err != nil {
+			return nil, fmt.Errorf(errWasm, "section contents")
+		}
+
+		switch id {
+		case WASM_SECTION_CUSTOM:
+			if isGoWasmSection(contents) {
+				x.ptrsz = 8
+			}
+		case WASM_SECTION_DATA:
+			if err := x.readDataSection(contents, offset); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return x, nil
+}
+
+// readUleb128 reads an unsigned LEB128 integer
+func readUleb128(r io.ByteReader) (uint64, error) {
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf(errWasm, "integer overflow")
+}
+
+// readSleb128 reads a signed LEB128 integer
+func readSleb128(r io.ByteReader) (int64, error) {
+	var v int64
+	for shift := 0; shift < 64; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v |= int64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			if shift+7 < 64 && b&0x40 != 0 {
+				// sign extend
+				v |= -1 << (shift + 7)
+			}
+			return v, nil
+		}
+	}
+	return 0, fmt.Errorf(errWasm, "integer overflow")
+}
+
+// isGoWasmSection reports whether the custom section identifies a module
+// built by the Go toolchain
+func isGoWasmSection(contents []byte) bool {
+	r := bytes.NewReader(contents)
+	n, err := readUleb128(r)
+	if err != nil || n > uint64(r.Len()) {
+		return false
+	}
+
+	name := make([]byte, n)
+	r.Read(name)
+	switch string(name) {
+	case "go:buildid":
+		return true
+	case "producers":
+		return bytes.Contains(contents, []byte("Go cmd/compile"))
+	}
+	return false
+}
+
+// readDataSection parses the data segments of the section located at offset
+func (x *exeWasm) readDataSection(contents []byte, offset int64) error {
+	r := bytes.NewReader(contents)
+
+	count, err := readUleb128(r)
+	if err != nil {
+		return fmt.Errorf(errWasm, "data section")
+	}
+
+	for i := uint64(0); i < count; i++ {
+		flags, err := readUleb128(r)
+		if err != nil {
+			return fmt.Errorf(errWasm, "data segment")
+		}
+
+		// passive segments (1) are copied at runtime to unknown addresses
+		active := flags != 1
+		if flags == 2 {
+			// memory index
+			if _, err := readUleb128(r); err != nil {
+				return fmt.Errorf(errWasm, "data segment")
+			}
+		}
+
+		var addr uint64
+		if active {
+			if addr, active, err = readConstExpr(r); err != nil {
+				return fmt.Errorf(errWasm, "data segment offset")
+			}
+		}
+
+		n, err := readUleb128(r)
+		if err != nil || n > uint64(r.Len()) {
+			return fmt.Errorf(errWasm, "data segment")
+		}
+		pos := offset + int64(len(contents)-r.Len())
+		r.Seek(int64(n), io.SeekCurrent)
+
+		if !active || n == 0 {
+			continue
+		}
+		x.Segments = append(x.Segments, &WasmSegment{Addr: addr, Offset: pos, Size: n})
+	}
+
+	return nil
+}
+
+// readConstExpr evaluates the offset expression of an active segment. Returns
+// false if the offset is not a constant, such as a global.get of an imported
+// memory base.
+func readConstExpr(r *bytes.Reader) (uint64, bool, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var v int64
+	constant := op == WASM_OP_I32_CONST || op == WASM_OP_I64_CONST
+	if constant {
+		v, err = readSleb128(r)
+	} else {
+		// the only other valid expression reads a global
+		_, err = readUleb128(r)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	if end, err := r.ReadByte(); err != nil || end != WASM_OP_END {
+		return 0, false, fmt.Errorf(errWasm, "expression")
+	}
+	if op == WASM_OP_I32_CONST {
+		return uint64(uint32(v)), true, nil
+	}
+	return uint64(v), constant, nil
+}
+
+func (x *exeWasm) FormatName() string { return "WebAssembly" }
+
+func (x *exeWasm) Rodata() (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, ".rodata")
+}
+
+func (x *exeWasm) SectionData(name string) (*SectionData, error) {
+	return nil, fmt.Errorf(errSectionNonexistent, name)
+}
+
+// Sections returns views of the linear memory built from the data segments.
+// The Go linker omits runs of zeroes from its segments, so nearby segments are
+// copied into a single zero-filled view where embed tables may point across
+// them. File offsets of the views are linear memory addresses.
+func (x *exeWasm) Sections() ([]*SectionData, error) {
+	if len(x.Segments) == 0 {
+		return nil, fmt.Errorf(errWasmData)
+	}
+
+	segments := make([]*WasmSegment, len(x.Segments))
+	copy(segments, x.Segments)
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Addr < segments[j].Addr })
+
+	sections := []*SectionData{}
+	for len(segments) > 0 {
+		start, end := segments[0].Addr, segments[0].Addr+segments[0].Size
+
+		n := 1
+		for ; n < len(segments) && segments[n].Addr <= end+WASM_PAGE_SIZE; n++ {
+			end = max(end, segments[n].Addr+segments[n].Size)
+		}
+		if end-start > uint64(MAX_FILE_SIZE) {
+			return nil, fmt.Errorf(errWasm, "data segments too large")
+		}
+
+		memory := make([]byte, end-start)
+		for _, s := range segments[:n] {
+			if _, err := x.r.ReadAt(memory[s.Addr-start:s.Addr-start+s.Size], s.Offset); err != nil {
+				return nil, fmt.Errorf(errWasm, "data segment contents")
+			}
+		}
+
+		sd := &SectionData{
+			Name: fmt.Sprintf("memory %#x", start),
+
+			VirtualAddr: start,
+			VirtualSize: end - start,
+			FileOffset:  start,
+			FileSize:    end - start,
+
+			Order: binary.LittleEndian,
+			Ptrsz: x.ptrsz,
+		}
+		sd.Load(memory)
+		sections = append(sections, sd)
+
+		slog.Debug("Built linear memory view", hexAttr("start", start), hexAttr("end", end), "segments", n)
+		segments = segments[n:]
+	}
+
+	return sections, nil
+}
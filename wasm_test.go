@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func appendUleb128(b []byte, v uint64) []byte {
+	for ; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+func appendSleb128(b []byte, v int64) []byte {
+	for v < -0x40 || v >= 0x40 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v)&0x7f)
+}
+
+// wasmSection appends the section id with its contents to module m
+func wasmSection(m []byte, id byte, contents []byte) []byte {
+	return append(appendUleb128(append(m, id), uint64(len(contents))), contents...)
+}
+
+// wasmSegment returns a data segment with flags, the constant offset
+// expression of op, or a global.get when op is 0, and contents
+func wasmSegment(flags uint64, op byte, addr int64, contents string) []byte {
+	b := appendUleb128(nil, flags)
+	if flags == 2 {
+		b = append(b, 0)
+	}
+	switch {
+	case flags == 1:
+	case op == 0:
+		b = append(b, 0x23, 0, WASM_OP_END)
+	default:
+		b = append(appendSleb128(append(b, op), addr), WASM_OP_END)
+	}
+	return append(appendUleb128(b, uint64(len(contents))), contents...)
+}
+
+// testWasm returns a module built by the Go toolchain with segments at 0x1000
+// and 0x1010, merged in a single view, and one a page away at 0x100000
+func testWasm() []byte {
+	segments := [][]byte{
+		wasmSegment(0, WASM_OP_I32_CONST, 0x1000, "hello"),
+		wasmSegment(1, 0, 0, "passive"),
+		wasmSegment(2, WASM_OP_I64_CONST, 0x1010, "world"),
+		wasmSegment(0, 0, 0, "imported base"),
+		wasmSegment(0, WASM_OP_I32_CONST, 0x100000, "far"),
+	}
+	data := appendUleb128(nil, uint64(len(segments)))
+	for _, s := range segments {
+		data = append(data, s...)
+	}
+
+	m := []byte(WASM_MAGIC + "\x01\x00\x00\x00")
+	m = wasmSection(m, WASM_SECTION_CUSTOM, append(appendUleb128(nil, 10), "go:buildid"...))
+	return wasmSection(m, WASM_SECTION_DATA, data)
+}
+
+func TestWasm(t *testing.T) {
+	img := testWasm()
+	x, err := NewWasm(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.ptrsz != 8 {
+		t.Errorf("got pointer size %d, want 8", x.ptrsz)
+	}
+
+	// passive segments and segments at a global offset are dropped
+	if len(x.Segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(x.Segments))
+	}
+	for i, s := range x.Segments {
+		want := []string{"hello", "world", "far"}[i]
+		if got := string(img[s.Offset : s.Offset+int64(s.Size)]); got != want {
+			t.Errorf("segment %#x: got %q, want %q", s.Addr, got, want)
+		}
+	}
+
+	sections, err := x.Sections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(sections))
+	}
+	want := append([]byte("hello"), make([]byte, 11)...)
+	want = append(want, "world"...)
+	if s := sections[0]; s.VirtualAddr != 0x1000 || !reflect.DeepEqual(s.Bytes, want) {
+		t.Errorf("got view %#x %q, want 0x1000 %q", s.VirtualAddr, s.Bytes, want)
+	}
+	if s := sections[1]; s.VirtualAddr != 0x100000 || string(s.Bytes) != "far" {
+		t.Errorf("got view %#x %q, want 0x100000 \"far\"", s.VirtualAddr, s.Bytes)
+	}
+}
+
+func TestWasmNegativeOffset(t *testing.T) {
+	data := append(appendUleb128(nil, 1), wasmSegment(0, WASM_OP_I32_CONST, -16, "top")...)
+	m := wasmSection([]byte(WASM_MAGIC+"\x01\x00\x00\x00"), WASM_SECTION_DATA, data)
+
+	x, err := NewWasm(bytes.NewReader(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// i32 offsets wrap around the 32-bit linear memory
+	if len(x.Segments) != 1 || x.Segments[0].Addr != 0xfffffff0 {
+		t.Errorf("got segments %+v", x.Segments)
+	}
+}
+
+func TestWasmTruncated(t *testing.T) {
+	img := testWasm()
+
+	// section header, section contents and module header cut short
+	for _, n := range []int{len(img) - 1, len(img) - 20, 9, 4} {
+		if _, err := NewWasm(bytes.NewReader(img[:n])); err == nil {
+			t.Errorf("truncated at %d: no error", n)
+		}
+	}
+
+	// a segment larger than its section
+	data := append(appendUleb128(nil, 1), wasmSegment(0, WASM_OP_I32_CONST, 0, "hello")...)
+	data = data[:len(data)-1]
+	m := wasmSection([]byte(WASM_MAGIC+"\x01\x00\x00\x00"), WASM_SECTION_DATA, data)
+	if _, err := NewWasm(bytes.NewReader(m)); err == nil {
+		t.Error("oversized segment: no error")
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	// malformed XCOFF file: %s
+	errXCOFF = "malformed XCOFF file: %s"
+)
+
+const (
+	// U802TOCMAGIC is the 32-bit magic, only 64-bit executables are built by Go
+	XCOFF64_MAGIC = "\x01\xf7"
+
+	// reference: https://www.ibm.com/docs/en/aix/7.3?topic=formats-xcoff-object-file-format
+	XCOFF64_FILE_HEADER_SIZE    = 24
+	XCOFF64_SECTION_HEADER_SIZE = 72
+)
+
+// XCOFFSection is a section header of an XCOFF64 file
+type XCOFFSection struct {
+	Name   string
+	Addr   uint64
+	Size   uint64
+	Offset uint64
+	Flags  uint32
+}
+
+// exeXCOFF is an AIX XCOFF64 executable. The standard library parser is
+// internal to the Go toolchain, only the section headers are needed here.
+type exeXCOFF struct {
+	r        io.ReaderAt
+	sections []*XCOFFSection
+}
+
+// NewXCOFF parses the section headers of the XCOFF64 file r
+func NewXCOFF(r io.ReaderAt) (*exeXCOFF, error) {
+	hdr := make([]byte, XCOFF64_FILE_HEADER_SIZE)
+	if _, err := r.ReadAt(hdr, 0); err != nil {
+		return nil, fmt.Errorf(errXCOFF, "file header")
+	}
+	if string(hdr[:2]) != XCOFF64_MAGIC {
+		return nil, fmt.Errorf(errUnrecognizedFormat)
+	}
+
+	count := int64(binary.BigEndian.Uint16(hdr[2:4]))
+	// the auxiliary header precedes the section headers
+	offset := int64(XCOFF64_FILE_HEADER_SIZE) + int64(binary.BigEndian.Uint16(hdr[16:18]))
+
+	table := make([]byte, count*XCOFF64_SECTION_HEADER_SIZE)
+	if _, err := r.ReadAt(table, offset); err != nil {
+		return nil, fmt.Errorf(errXCOFF, "section headers")
+	}
+
+	x := &exeXCOFF{r: r}
+	for i := int64(0); i < count; i++ {
+		b := table[i*XCOFF64_SECTION_HEADER_SIZE:]
+
+		x.sections = append(x.sections, &XCOFFSection{
+			Name:   string(bytes.TrimRight(b[0:8], "\x00")),
+			Addr:   binary.BigEndian.Uint64(b[16:24]),
+			Size:   binary.BigEndian.Uint64(b[24:32]),
+			Offset: binary.BigEndian.Uint64(b[32:40]),
+			Flags:  binary.BigEndian.Uint32(b[64:68]),
+		})
+	}
+
+	return x, nil
+}
+
+func (x *exeXCOFF) FormatName() string { return "XCOFF64" }
+
+// Go places read-only data in the text section on AIX
+func (x *exeXCOFF) Rodata() (*SectionData, error) { return x.SectionData(".text") }
+
+// Sections scans .text along with .data, with external linking the tables
+// holding relocations are moved to .data and point at names in .text
+// reference: /src/cmd/link/internal/ld/data.go (Segrelrodata)
+func (x *exeXCOFF) Sections() ([]*SectionData, error) {
+	text, err := x.Rodata()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := x.SectionData(".data")
+	if err != nil {
+		return []*SectionData{text}, nil
+	}
+	return []*SectionData{newSectionView(".text+.data", []*SectionData{text, data})}, nil
+}
+
+func (x *exeXCOFF) SectionData(name string) (*SectionData, error) {
+	var s *XCOFFSection
+	for _, sh := range x.sections {
+		if sh.Name == name {
+			s = sh
+			break
+		}
+	}
+	// .bss occupies no space in the file
+	if s == nil || s.Offset == 0 {
+		return nil, fmt.Errorf(errSectionNonexistent, name)
+	}
+
+	d := SectionData{
+		Name: name,
+
+		VirtualAddr: s.Addr,
+		VirtualSize: s.Size,
+		BaseAddr:    0,
+
+		FileOffset: s.Offset,
+		FileSize:   s.Size,
+
+		// AIX only runs on big-endian POWER
+		Order: binary.BigEndian,
+		Ptrsz: 8,
+		Data:  io.NewSectionReader(x.r, int64(s.Offset), int64(s.Size)),
+	}
+
+	return &d, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// testXCOFF returns an XCOFF64 executable whose embed table is in .data while
+// the names and contents of its files are in .text, as with external linking
+func testXCOFF() []byte {
+	const (
+		textOff, textAddr = 0x100, 0x100000100
+		dataOff, dataAddr = 0x200, 0x110000200
+		secSize           = 0x100
+	)
+	be := binary.BigEndian
+
+	img := make([]byte, dataOff+secSize)
+	copy(img, XCOFF64_MAGIC)
+	be.PutUint16(img[2:], 3)
+
+	for i, s := range []struct {
+		name               string
+		addr, size, offset uint64
+	}{
+		{".text", textAddr, secSize, textOff},
+		{".data", dataAddr, secSize, dataOff},
+		{".bss", dataAddr + secSize, 0x40, 0},
+	} {
+		h := img[XCOFF64_FILE_HEADER_SIZE+i*XCOFF64_SECTION_HEADER_SIZE:]
+		copy(h[0:8], s.name)
+		be.PutUint64(h[8:], s.addr)
+		be.PutUint64(h[16:], s.addr)
+		be.PutUint64(h[24:], s.size)
+		be.PutUint64(h[32:], s.offset)
+	}
+
+	putEmbedFS(img, be, dataOff, dataAddr, textOff, textAddr, embedFiles)
+	return img
+}
+
+func TestXCOFF(t *testing.T) {
+	img := testXCOFF()
+
+	x, err := DetectExeFormat(bytes.NewReader(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	xc, ok := x.(*exeXCOFF)
+	if !ok {
+		t.Fatalf("detected %s", x.FormatName())
+	}
+	if len(xc.sections) != 3 || xc.sections[1].Name != ".data" || xc.sections[1].Addr != 0x110000200 || xc.sections[1].Offset != 0x200 {
+		t.Fatalf("parsed sections %+v", xc.sections[1])
+	}
+	if _, err := x.SectionData(".bss"); err == nil {
+		t.Error(".bss has contents")
+	}
+
+	sections, err := x.Sections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sections) != 1 || sections[0].ScanSize() != 0x200 {
+		t.Fatalf("got %d sections, want a single view of .text and .data", len(sections))
+	}
+
+	candidates := findCandidates(sections[0], DefaultScanOptions())
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, want 1", len(candidates))
+	}
+	for i, f := range embedFiles {
+		e := candidates[0].Entry(uint64(i))
+		if data, err := e.Read(); err != nil || e.Name != f.name || string(data) != f.data {
+			t.Errorf("entry %s: read %q (%v), want %s %q", e.Name, data, err, f.name, f.data)
+		}
+	}
+}
+
+func TestXCOFFTruncated(t *testing.T) {
+	img := testXCOFF()
+
+	for _, n := range []int{XCOFF64_FILE_HEADER_SIZE - 1, XCOFF64_FILE_HEADER_SIZE + XCOFF64_SECTION_HEADER_SIZE} {
+		if _, err := NewXCOFF(bytes.NewReader(img[:n])); err == nil {
+			t.Errorf("truncated to %d bytes: no error", n)
+		}
+	}
+}